@@ -0,0 +1,263 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"toolkit/utils"
+)
+
+const maxArchiveFiles = 10000
+
+type archiveMember struct {
+	fp      string // 绝对路径
+	rel     string // 相对工作目录的路径，用于下载跟踪
+	name    string // 压缩包内的路径
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+// 将选中的文件或整个文件夹打包为 zip/tar.gz 流式下载，不落地临时文件
+// 查询参数（或表单）path 为所在文件夹，name 可重复指定要打包的文件或文件夹，缺省时打包整个 path
+// format 为 zip（默认）或 tar.gz
+func archive(c *utils.Ctx) {
+	var now = time.Now()
+	if err := c.R.ParseForm(); err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "参数错误", err)
+		return
+	}
+
+	format := c.R.Form.Get("format")
+	switch format {
+	case "", "zip":
+		format = "zip"
+	case "tar.gz", "tgz":
+		format = "tar.gz"
+	default:
+		writeErrorRsp(c, http.StatusBadRequest, "不支持的压缩格式", nil, format)
+		return
+	}
+
+	base := strings.Trim(c.R.Form.Get("path"), "/")
+	baseDir, baseRel, err := resolvePath(base)
+	if err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, base)
+		return
+	}
+
+	names := c.R.Form["name"]
+	archiveName := filepath.Base(baseDir)
+	if len(names) == 0 {
+		names = []string{""}
+	} else if len(names) == 1 {
+		archiveName = path.Base(strings.Trim(names[0], "/"))
+	}
+	if baseRel == "" && len(names) != 1 {
+		archiveName = hostName
+	}
+
+	var members []archiveMember
+	var total int64
+	for _, name := range names {
+		fp, rel, err := resolvePath(path.Join(baseRel, name))
+		if err == nil && baseRel != "" && rel != baseRel && !strings.HasPrefix(rel, baseRel+"/") {
+			err = errInvalidPath
+		}
+		if err != nil {
+			writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, name)
+			return
+		}
+		info, err := os.Stat(fp)
+		if err != nil {
+			writeErrorRsp(c, http.StatusNotFound, "文件不存在", err, rel)
+			return
+		}
+		err = collectMembers(fp, rel, baseRel, info, &members, &total)
+		if err != nil {
+			writeErrorRsp(c, http.StatusRequestEntityTooLarge, err.Error(), nil, rel)
+			return
+		}
+	}
+
+	var fileCount int
+	for _, m := range members {
+		if !m.isDir {
+			fileCount++
+			dlTracker.Start(m.rel)
+			defer dlTracker.End(m.rel)
+		}
+	}
+	if fileCount == 0 {
+		writeErrorRsp(c, http.StatusNotFound, "没有可下载的文件", nil, baseRel)
+		return
+	}
+
+	archiveName += "." + format
+	contentType := "application/zip"
+	if format == "tar.gz" {
+		contentType = "application/gzip"
+	}
+	c.W.Header().Set("Content-Type", contentType)
+	c.W.Header().Set("X-Content-Type-Options", "nosniff")
+	c.W.Header().Set("Content-Disposition", attachmentDisposition(archiveName))
+
+	w, done := bwMgr.Writer(c)
+	defer done()
+	sw := &statWriter{ResponseWriter: metricsMgr.Writer(w), status: http.StatusOK}
+	if format == "zip" {
+		err = writeZip(sw, members)
+	} else {
+		err = writeTarGz(sw, members)
+	}
+	if err != nil {
+		audit(c, "archive", path.Join(baseRel, archiveName), sw.written, time.Since(now), http.StatusInternalServerError, "")
+		// 响应头已发出，只能中断连接让客户端感知下载失败
+		c.Errorf("打包失败: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	audit(c, "archive", path.Join(baseRel, archiveName), sw.written, time.Since(now), sw.status, "")
+	logTransfer(c, fmt.Sprintf("%s(%d)", archiveName, fileCount), sw.written, time.Since(now))
+}
+
+// 收集需要打包的文件，跳过临时文件、程序文件及隐藏文件，同时检查数量及大小限制
+func collectMembers(root, rootRel, baseRel string, rootInfo os.FileInfo, members *[]archiveMember, total *int64) error {
+	add := func(fp, rel string, info os.FileInfo) error {
+		name := strings.TrimPrefix(strings.TrimPrefix(rel, baseRel), "/")
+		if name == "" {
+			return nil
+		}
+		m := archiveMember{fp: fp, rel: rel, name: name, isDir: info.IsDir(), modTime: info.ModTime()}
+		if !m.isDir {
+			m.size = info.Size()
+			*total += m.size
+		}
+		*members = append(*members, m)
+		if len(*members) > maxArchiveFiles {
+			return fmt.Errorf("文件数量超出%d限制", maxArchiveFiles)
+		}
+		if maxFileSize := int64(curConf().MaxFileSize); *total > maxFileSize {
+			return fmt.Errorf("文件超出%s限制", utils.FormatBytesIEC(maxFileSize))
+		}
+		return nil
+	}
+
+	if !rootInfo.IsDir() {
+		if !rootInfo.Mode().IsRegular() || utils.IsIgnoreFile(rootInfo) ||
+			isSidecarFile(root) || strings.HasSuffix(rootInfo.Name(), tmpSuffix) {
+			return nil
+		}
+		return add(root, rootRel, rootInfo)
+	}
+
+	return filepath.WalkDir(root, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 无权限等读取失败的文件夹直接跳过
+			if d != nil && d.IsDir() && fp != root {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if isSidecarFile(fp) || strings.HasSuffix(d.Name(), tmpSuffix) ||
+			(!d.IsDir() && !d.Type().IsRegular()) {
+			if d.IsDir() && fp != root {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if fp != root && utils.IsIgnoreFile(info) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(workDir, fp)
+		if err != nil {
+			return nil
+		}
+		return add(fp, filepath.ToSlash(rel), info)
+	})
+}
+
+func writeZip(w io.Writer, members []archiveMember) error {
+	zw := zip.NewWriter(w)
+	for _, m := range members {
+		hdr := &zip.FileHeader{Name: m.name, Modified: m.modTime}
+		if m.isDir {
+			hdr.Name += "/"
+			if _, err := zw.CreateHeader(hdr); err != nil {
+				return err
+			}
+			continue
+		}
+		hdr.Method = zip.Deflate
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if err = copyMember(fw, m); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, members []archiveMember) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m.name, ModTime: m.modTime, Mode: 0644, Typeflag: tar.TypeReg, Size: m.size}
+		if m.isDir {
+			hdr.Name += "/"
+			hdr.Mode = 0755
+			hdr.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if m.isDir {
+			continue
+		}
+		if err := copyMember(tw, m); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// 按收集时的大小写入文件内容，文件在打包过程中被修改时报错
+func copyMember(w io.Writer, m archiveMember) error {
+	f, err := os.Open(m.fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := uploadBufPool.Get().([]byte)
+	defer uploadBufPool.Put(buf)
+	n, err := io.CopyBuffer(w, io.LimitReader(f, m.size), buf)
+	if err != nil {
+		return err
+	}
+	if n != m.size {
+		return fmt.Errorf("%s: %w", m.rel, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCollectMembers(t *testing.T) {
+	dir := t.TempDir()
+	old := workDir
+	workDir = dir
+	t.Cleanup(func() { workDir = old })
+
+	files := map[string]string{
+		"docs/a.txt":           "abc",
+		"docs/sub/b.txt":       "hello",
+		"docs/c.txt" + ".part": "partial",
+		"docs/gfss.json":       "{}",
+	}
+	for name, data := range files {
+		fp := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Symlink(filepath.Join(dir, "docs", "a.txt"), filepath.Join(dir, "docs", "link.txt"))
+	sidecar := filepath.Join(dir, "docs", "gfss.json")
+	addSidecarFiles(sidecar)
+	t.Cleanup(func() { delete(sidecarFiles, sidecarKey(sidecar)) })
+
+	tests := []struct {
+		root, baseRel string
+		names         []string
+		total         int64
+	}{
+		{"docs", "", []string{"docs", "docs/a.txt", "docs/sub", "docs/sub/b.txt"}, 8},
+		{"docs", "docs", []string{"a.txt", "sub", "sub/b.txt"}, 8},
+		{"docs/sub", "docs", []string{"sub", "sub/b.txt"}, 5},
+		{"docs/a.txt", "docs", []string{"a.txt"}, 3},
+		// 直接选中的临时及附属文件同样跳过
+		{"docs/c.txt.part", "docs", nil, 0},
+		{"docs/gfss.json", "docs", nil, 0},
+	}
+	for _, tt := range tests {
+		fp := filepath.Join(dir, filepath.FromSlash(tt.root))
+		info, err := os.Stat(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var members []archiveMember
+		var total int64
+		if err = collectMembers(fp, tt.root, tt.baseRel, info, &members, &total); err != nil {
+			t.Errorf("collectMembers(%q, %q): %v", tt.root, tt.baseRel, err)
+			continue
+		}
+		var names []string
+		for _, m := range members {
+			names = append(names, m.name)
+			if want := filepath.Join(dir, filepath.FromSlash(m.rel)); m.fp != want {
+				t.Errorf("%s: fp = %q, want %q", m.name, m.fp, want)
+			}
+		}
+		if !slices.Equal(names, tt.names) || total != tt.total {
+			t.Errorf("collectMembers(%q, %q) = %v (%d bytes), want %v (%d bytes)",
+				tt.root, tt.baseRel, names, total, tt.names, tt.total)
+		}
+	}
+
+	// 超出单文件大小限制时报错
+	oldConf := curConf()
+	t.Cleanup(func() { confPtr.Store(oldConf) })
+	updateConfig(func(conf *Config) { conf.MaxFileSize = 7 })
+	fp := filepath.Join(dir, "docs")
+	info, _ := os.Stat(fp)
+	var members []archiveMember
+	var total int64
+	if err := collectMembers(fp, "docs", "", info, &members, &total); err == nil {
+		t.Error("size limit not enforced")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"toolkit/utils"
+)
+
+// 查询默认及最多返回的记录数
+const (
+	defaultAuditLimit = 200
+	maxAuditLimit     = 5000
+)
+
+// 一条审计记录，一行一个 JSON 对象
+type AuditRecord struct {
+	Time     int64  `json:"time"` // 毫秒时间戳
+	IP       string `json:"ip"`   // 后台清理时为空
+	User     string `json:"user,omitempty"`
+	Op       string `json:"op"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Duration int64  `json:"duration"` // 毫秒
+	Status   int    `json:"status"`
+	SHA256   string `json:"sha256,omitempty"`
+}
+
+// 只追加的审计日志，保存在程序所在目录，不随运行日志截断
+type AuditLog struct {
+	mux  sync.Mutex
+	file string
+	f    *os.File
+}
+
+func NewAuditLog(file string) *AuditLog {
+	return &AuditLog{file: file}
+}
+
+func auditFile() string {
+	return filepath.Join(filepath.Dir(execPath), "gfss_audit.jsonl")
+}
+
+func (t *AuditLog) Add(rec AuditRecord) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.f == nil {
+		if t.f, err = os.OpenFile(t.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644); err != nil {
+			t.f = nil
+			log.Error("打开审计日志失败", err)
+			return
+		}
+	}
+	if _, err = t.f.Write(b); err != nil {
+		log.Error("写入审计日志失败", err)
+		// 文件可能被删除或移动，下次重新打开
+		t.f.Close()
+		t.f = nil
+	}
+}
+
+// 查询 [from, to) 范围内的记录，为 0 时不限制，ip 及 op 为空时不过滤，最新的在前
+func (t *AuditLog) Query(from, to int64, ip, op string, limit int) ([]AuditRecord, error) {
+	// 单独打开文件读取，不阻塞写入，最后一行可能不完整
+	f, err := os.Open(t.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []AuditRecord{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	// 按时间顺序写入长度为 limit 的环形缓冲区，只保留最后 limit 条，next 为下一条的位置
+	list := make([]AuditRecord, 0, min(limit, defaultAuditLimit))
+	var next int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec AuditRecord
+		if json.Unmarshal(line, &rec) != nil {
+			continue
+		}
+		if (from > 0 && rec.Time < from) || (to > 0 && rec.Time >= to) ||
+			(ip != "" && rec.IP != ip) || (op != "" && rec.Op != op) {
+			continue
+		}
+		if len(list) < limit {
+			list = append(list, rec)
+		} else {
+			list[next] = rec
+		}
+		next = (next + 1) % limit
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	// 缓冲区已满时 next 处为最早的一条
+	if len(list) == limit {
+		list = slices.Concat(list[next:], list[:next])
+	}
+	slices.Reverse(list)
+	return list, nil
+}
+
+func (t *AuditLog) Close() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.f != nil {
+		t.f.Close()
+		t.f = nil
+	}
+}
+
+// 记录一次文件操作及传输耗时，elapsed 为操作耗时，sum 为文件的 SHA-256，未知时为空
+func audit(c *utils.Ctx, op, rel string, size int64, elapsed time.Duration, status int, sum string) {
+	rec := AuditRecord{
+		Time:     time.Now().UnixMilli(),
+		Op:       op,
+		Path:     rel,
+		Size:     size,
+		Duration: elapsed.Milliseconds(),
+		Status:   status,
+		SHA256:   sum,
+	}
+	if c != nil {
+		rec.IP = c.ID
+		if c.R != nil && authEnabled() {
+			rec.User, _, _ = sessionUser(c.R)
+		}
+	}
+	auditLog.Add(rec)
+	metricsMgr.Transfer(op, elapsed, status)
+}
+
+// 查询审计日志，仅限本机访问
+// 参数 from、to 为毫秒时间戳或 2006-01-02[ 15:04:05] 格式的本地时间，ip、op 用于过滤，limit 为最多返回的记录数
+func auditQuery(c *utils.Ctx) {
+	if !utils.IsLocalIP(c.ID) {
+		writeErrorRsp(c, http.StatusForbidden, "仅限本机访问", nil, c.R.URL.Path)
+		return
+	}
+	q := c.R.URL.Query()
+	from, ok := parseAuditTime(q.Get("from"))
+	if !ok {
+		writeErrorRsp(c, http.StatusBadRequest, "无效时间", nil, q.Get("from"))
+		return
+	}
+	to, ok := parseAuditTime(q.Get("to"))
+	if !ok {
+		writeErrorRsp(c, http.StatusBadRequest, "无效时间", nil, q.Get("to"))
+		return
+	}
+	limit := defaultAuditLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErrorRsp(c, http.StatusBadRequest, "参数错误", err, v)
+			return
+		}
+		limit = min(n, maxAuditLimit)
+	}
+
+	list, err := auditLog.Query(from, to, q.Get("ip"), q.Get("op"), limit)
+	if err != nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "读取审计日志失败", err)
+		return
+	}
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.W.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(c.W).Encode(list)
+}
+
+func parseAuditTime(v string) (int64, bool) {
+	if v == "" {
+		return 0, true
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, n >= 0
+	}
+	for _, layout := range []string{time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t.UnixMilli(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAuditLogQuery(t *testing.T) {
+	l := NewAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	defer l.Close()
+	for i := range 10 {
+		op := "download"
+		if i%3 == 0 {
+			op = "upload"
+		}
+		l.Add(AuditRecord{Time: int64(i + 1), IP: "10.0.0.1", Op: op, Path: "f"})
+	}
+
+	tests := []struct {
+		from, to int64
+		op       string
+		limit    int
+		want     []int64 // 时间，最新的在前
+	}{
+		{0, 0, "", 3, []int64{10, 9, 8}},
+		{0, 0, "", 10, []int64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{0, 0, "", 20, []int64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{0, 0, "upload", 2, []int64{10, 7}},
+		{3, 7, "", 2, []int64{6, 5}},
+		{3, 7, "", 1, []int64{6}},
+		{0, 0, "delete", 5, nil},
+	}
+	for _, tt := range tests {
+		list, err := l.Query(tt.from, tt.to, "", tt.op, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for _, rec := range list {
+			got = append(got, rec.Time)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Query(%d, %d, %q, %d) = %v, want %v", tt.from, tt.to, tt.op, tt.limit, got, tt.want)
+		}
+	}
+}
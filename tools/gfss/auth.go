@@ -0,0 +1,402 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"toolkit/utils"
+)
+
+const (
+	permPublic = -1 // 无需登录
+	permLogin  = 0  // 登录即可
+	permRead   = 1 << 0
+	permUpload = 1 << 1
+	permDelete = 1 << 2
+	permShare  = 1 << 3
+)
+
+var rolePerms = map[string]int{
+	"read":   permRead,
+	"upload": permUpload,
+	"full":   permRead | permUpload | permDelete | permShare,
+}
+
+const sessionCookie = "gfss_session"
+const sessionTTL = 7 * 24 * time.Hour
+
+const maxLoginFails = 5
+const loginBlockTime = 10 * time.Minute
+
+type AuthUser struct {
+	Name string `json:"name"`
+	// 明文密码，或 sha256: 开头的十六进制摘要
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+type AuthToken struct {
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// 用户配置文件格式
+type AuthConfig struct {
+	Users  []AuthUser  `json:"users"`
+	Tokens []AuthToken `json:"tokens"`
+}
+
+var cliToken *AuthToken
+var sessionKey []byte
+var loginLimiter = NewLoginLimiter()
+
+func authEnabled() bool {
+	conf := curConf()
+	return conf.Password != "" || len(conf.auth.Users) > 0 || len(conf.auth.Tokens) > 0
+}
+
+// 读取或生成会话密钥，tokenRole 不为空时生成一个该角色的访问令牌并返回
+func initAuth(keyFile, tokenRole string) (string, error) {
+	var err error
+	if sessionKey, err = loadSessionKey(keyFile); err != nil {
+		return "", err
+	}
+
+	if tokenRole == "" {
+		return "", nil
+	}
+	if _, ok := rolePerms[tokenRole]; !ok {
+		return "", fmt.Errorf("无效令牌角色: %s", tokenRole)
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b[:])
+	cliToken = &AuthToken{Token: token, Role: tokenRole}
+	return token, nil
+}
+
+// 会话密钥保存在程序目录，重启后已登录的会话及分享链接仍然有效，删除该文件可使其全部失效
+func loadSessionKey(file string) ([]byte, error) {
+	if b, err := os.ReadFile(file); err == nil {
+		if key, err := hex.DecodeString(strings.TrimSpace(string(b))); err == nil && len(key) == 32 {
+			return key, nil
+		}
+		log.Error("会话密钥无效，重新生成", file)
+	} else if !os.IsNotExist(err) {
+		log.Error("读取会话密钥失败", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	// 保存失败时仍可使用，只是重启后失效
+	if err := os.WriteFile(file, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		log.Error("保存会话密钥失败", err)
+	}
+	return key, nil
+}
+
+func sessionKeyFile() string {
+	return filepath.Join(filepath.Dir(execPath), "gfss_session.key")
+}
+
+// 读取用户配置文件
+func loadUsers(file string) (AuthConfig, error) {
+	var conf AuthConfig
+	b, err := os.ReadFile(utils.NormalizePath(file))
+	if err != nil {
+		return conf, err
+	}
+	if err = json.Unmarshal(b, &conf); err != nil {
+		return conf, fmt.Errorf("%s: %w", file, err)
+	}
+	return conf, nil
+}
+
+func checkAuthConfig(conf AuthConfig) error {
+	for _, u := range conf.Users {
+		if _, ok := rolePerms[u.Role]; !ok || u.Name == "" {
+			return fmt.Errorf("无效用户: %s(%s)", u.Name, u.Role)
+		}
+	}
+	for _, t := range conf.Tokens {
+		if _, ok := rolePerms[t.Role]; !ok || t.Token == "" {
+			return fmt.Errorf("无效令牌角色: %s", t.Role)
+		}
+	}
+	return nil
+}
+
+// 检查请求是否具备所需权限，未通过时已写入响应
+// 本机访问不受限制；未登录时优先使用会话 Cookie，其次为 URL 中的 token 参数或 HTTP Basic 认证
+func authorize(c *utils.Ctx, perm int) bool {
+	if perm == permPublic || !authEnabled() || utils.IsLocalIP(c.ID) {
+		return true
+	}
+
+	name, role, ok := sessionUser(c.R)
+	if !ok {
+		var status int
+		name, role, status = checkCredential(c)
+		switch status {
+		case http.StatusOK:
+			setSession(c, name, role)
+			// 通过链接中的令牌登录后去掉地址栏中的令牌
+			if c.R.Method == http.MethodGet && c.R.URL.Path == "/" &&
+				c.R.URL.Query().Has("token") {
+				http.Redirect(c.W, c.R, "/", http.StatusFound)
+				return false
+			}
+		case http.StatusTooManyRequests:
+			writeErrorRsp(c, status, "登录失败次数过多，请稍后再试", nil)
+			return false
+		default:
+			c.W.Header().Set("WWW-Authenticate", `Basic realm="gfss", charset="UTF-8"`)
+			writeErrorRsp(c, http.StatusUnauthorized, "请先登录", nil, c.R.URL.Path)
+			return false
+		}
+	}
+
+	if rolePerms[role]&perm != perm {
+		writeErrorRsp(c, http.StatusForbidden, "没有权限", nil, name, role, c.R.Method, c.R.URL.Path)
+		return false
+	}
+	return true
+}
+
+// 当前请求的角色，未启用认证或本机访问时为 full
+func currentRole(c *utils.Ctx) string {
+	if !authEnabled() || utils.IsLocalIP(c.ID) {
+		return "full"
+	}
+	_, role, _ := sessionUser(c.R)
+	return role
+}
+
+// 校验令牌或 Basic 认证信息，失败次数过多的 IP 暂时禁止登录
+func checkCredential(c *utils.Ctx) (name, role string, status int) {
+	token := c.R.URL.Query().Get("token")
+	user, pass, hasBasic := c.R.BasicAuth()
+	if token == "" && !hasBasic {
+		return "", "", http.StatusUnauthorized
+	}
+	return verifyLogin(c, user, pass, token)
+}
+
+func verifyLogin(c *utils.Ctx, user, pass, token string) (name, role string, status int) {
+	if loginLimiter.Blocked(c.ID) {
+		return "", "", http.StatusTooManyRequests
+	}
+
+	if token != "" {
+		name, role = "token", matchToken(token)
+	} else {
+		name, role = user, matchPassword(user, pass)
+	}
+	if role == "" {
+		loginLimiter.Fail(c.ID)
+		c.Log(1, "err", "登录失败", name)
+		return "", "", http.StatusUnauthorized
+	}
+
+	loginLimiter.Reset(c.ID)
+	c.Log(1, "inf", "登录", name, role)
+	return name, role, http.StatusOK
+}
+
+func matchToken(token string) string {
+	for _, t := range curConf().auth.Tokens {
+		if secretEqual(token, t.Token) {
+			return t.Role
+		}
+	}
+	return ""
+}
+
+func matchPassword(user, pass string) string {
+	conf := curConf()
+	for _, u := range conf.auth.Users {
+		if u.Name != user {
+			continue
+		}
+		if hexSum, ok := strings.CutPrefix(u.Password, "sha256:"); ok {
+			sum := sha256.Sum256([]byte(pass))
+			if secretEqual(hex.EncodeToString(sum[:]), strings.ToLower(hexSum)) {
+				return u.Role
+			}
+		} else if secretEqual(pass, u.Password) {
+			return u.Role
+		}
+		return ""
+	}
+	// 共享密码忽略用户名
+	if conf.Password != "" && secretEqual(pass, conf.Password) {
+		return "full"
+	}
+	return ""
+}
+
+func secretEqual(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// 由会话密钥及认证配置派生 Cookie 签名密钥，删除用户、修改密码或角色后已签发的会话随之失效
+func cookieKey(password string, conf AuthConfig) []byte {
+	b, _ := json.Marshal(struct {
+		Password string
+		AuthConfig
+	}{password, conf})
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+// 会话 Cookie 格式：base64(role|过期时间|name).base64(HMAC-SHA256)
+func signSession(payload string) string {
+	mac := hmac.New(sha256.New, curConf().cookieKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func setSession(c *utils.Ctx, name, role string) {
+	expire := time.Now().Add(sessionTTL)
+	payload := fmt.Sprintf("%s|%d|%s", role, expire.Unix(), name)
+	http.SetCookie(c.W, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    signSession(payload),
+		Path:     "/",
+		Expires:  expire,
+		HttpOnly: true,
+		Secure:   c.R.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func sessionUser(r *http.Request) (name, role string, ok bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return
+	}
+	encoded, _, found := strings.Cut(cookie.Value, ".")
+	if !found {
+		return
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !hmac.Equal([]byte(signSession(string(payload))), []byte(cookie.Value)) {
+		return
+	}
+
+	parts := strings.SplitN(string(payload), "|", 3)
+	if len(parts) != 3 {
+		return
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return
+	}
+	if _, exist := rolePerms[parts[0]]; !exist {
+		return
+	}
+	return parts[2], parts[0], true
+}
+
+// 表单登录，参数 name、password 或 token
+func login(c *utils.Ctx) {
+	if !authEnabled() {
+		return
+	}
+	name, role, status := verifyLogin(c, c.R.FormValue("name"), c.R.FormValue("password"), c.R.FormValue("token"))
+	switch status {
+	case http.StatusOK:
+		setSession(c, name, role)
+		c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(c.W).Encode(map[string]string{"role": role})
+	case http.StatusTooManyRequests:
+		writeErrorRsp(c, status, "登录失败次数过多，请稍后再试", nil)
+	default:
+		writeErrorRsp(c, status, "用户名或密码错误", nil)
+	}
+}
+
+func logout(c *utils.Ctx) {
+	http.SetCookie(c.W, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+type loginFail struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// 按 IP 限制登录失败次数
+type LoginLimiter struct {
+	mux   sync.Mutex
+	fails map[string]*loginFail
+}
+
+func NewLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{
+		fails: make(map[string]*loginFail),
+	}
+}
+
+func (t *LoginLimiter) Blocked(ip string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	f, ok := t.fails[ip]
+	return ok && time.Now().Before(f.until)
+}
+
+func (t *LoginLimiter) Fail(ip string) {
+	var now = time.Now()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for k, f := range t.fails {
+		if now.Sub(f.last) > loginBlockTime && now.After(f.until) {
+			delete(t.fails, k)
+		}
+	}
+
+	f, ok := t.fails[ip]
+	if !ok {
+		f = &loginFail{}
+		t.fails[ip] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= maxLoginFails {
+		f.count = 0
+		f.until = now.Add(loginBlockTime)
+	}
+}
+
+func (t *LoginLimiter) Reset(ip string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	delete(t.fails, ip)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"toolkit/utils"
+)
+
+func setTestAuth(t *testing.T, password string, users []AuthUser, tokens []AuthToken) {
+	t.Helper()
+	old := curConf()
+	t.Cleanup(func() { confPtr.Store(old) })
+	conf := defaultConfig()
+	conf.Password, conf.Users, conf.Tokens = password, users, tokens
+	if err := conf.check(); err != nil {
+		t.Fatal(err)
+	}
+	applyConfig(conf)
+}
+
+func newSessionRequest(t *testing.T, name, role string) *http.Request {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c := &utils.Ctx{W: w, R: httptest.NewRequest(http.MethodGet, "/", nil)}
+	setSession(c, name, role)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestCheckAuthConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf AuthConfig
+		ok   bool
+	}{
+		{"empty", AuthConfig{}, true},
+		{"user", AuthConfig{Users: []AuthUser{{Name: "a", Password: "x", Role: "read"}}}, true},
+		{"token", AuthConfig{Tokens: []AuthToken{{Token: "t", Role: "upload"}}}, true},
+		{"bad role", AuthConfig{Users: []AuthUser{{Name: "a", Role: "admin"}}}, false},
+		{"no name", AuthConfig{Users: []AuthUser{{Role: "full"}}}, false},
+		{"empty token", AuthConfig{Tokens: []AuthToken{{Role: "full"}}}, false},
+		{"bad token role", AuthConfig{Tokens: []AuthToken{{Token: "t"}}}, false},
+	}
+	for _, tt := range tests {
+		if err := checkAuthConfig(tt.conf); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %t", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestMatchPassword(t *testing.T) {
+	// sha256("secret")
+	hashed := "sha256:2BB80D537B1DA3E38BD30361AA855686BDE0EACD7162FEF6A25FE97BF527A25B"
+	setTestAuth(t, "shared", []AuthUser{
+		{Name: "alice", Password: "pw", Role: "read"},
+		{Name: "bob", Password: hashed, Role: "upload"},
+	}, []AuthToken{{Token: "tok", Role: "upload"}})
+
+	tests := []struct {
+		user, pass, want string
+	}{
+		{"alice", "pw", "read"},
+		{"alice", "shared", ""}, // 已配置的用户不能使用共享密码
+		{"bob", "secret", "upload"},
+		{"bob", "pw", ""},
+		{"anyone", "shared", "full"},
+		{"anyone", "pw", ""},
+	}
+	for _, tt := range tests {
+		if got := matchPassword(tt.user, tt.pass); got != tt.want {
+			t.Errorf("matchPassword(%q, %q) = %q, want %q", tt.user, tt.pass, got, tt.want)
+		}
+	}
+	if got := matchToken("tok"); got != "upload" {
+		t.Errorf("matchToken = %q, want upload", got)
+	}
+	if got := matchToken("other"); got != "" {
+		t.Errorf("matchToken = %q, want empty", got)
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	sessionKey = []byte("test-key")
+	users := []AuthUser{{Name: "alice", Password: "pw", Role: "full"}}
+	setTestAuth(t, "", users, nil)
+
+	r := newSessionRequest(t, "alice", "full")
+	if name, role, ok := sessionUser(r); !ok || name != "alice" || role != "full" {
+		t.Fatalf("sessionUser = %q %q %t", name, role, ok)
+	}
+
+	// 篡改角色后签名不匹配
+	forged := httptest.NewRequest(http.MethodGet, "/", nil)
+	readSig := signSession("read|9999999999|alice")
+	fullPayload, _, _ := strings.Cut(signSession("full|9999999999|alice"), ".")
+	_, sig, _ := strings.Cut(readSig, ".")
+	forged.AddCookie(&http.Cookie{Name: sessionCookie, Value: fullPayload + "." + sig})
+	if _, _, ok := sessionUser(forged); ok {
+		t.Error("forged cookie accepted")
+	}
+
+	// 已过期
+	expired := httptest.NewRequest(http.MethodGet, "/", nil)
+	expired.AddCookie(&http.Cookie{Name: sessionCookie,
+		Value: signSession("full|" + strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10) + "|alice")})
+	if _, _, ok := sessionUser(expired); ok {
+		t.Error("expired cookie accepted")
+	}
+
+	// 降级用户后原会话失效
+	setTestAuth(t, "", []AuthUser{{Name: "alice", Password: "pw", Role: "read"}}, nil)
+	if _, _, ok := sessionUser(r); ok {
+		t.Error("session still valid after role change")
+	}
+
+	// 认证配置不变时重新加载不影响已有会话
+	setTestAuth(t, "", users, nil)
+	r = newSessionRequest(t, "alice", "full")
+	setTestAuth(t, "", users, nil)
+	if _, _, ok := sessionUser(r); !ok {
+		t.Error("session invalidated by unrelated reload")
+	}
+}
+
+func TestLoadSessionKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.key")
+	key, err := loadSessionKey(file)
+	if err != nil || len(key) != 32 {
+		t.Fatalf("loadSessionKey = %x, %v", key, err)
+	}
+	// 重启后使用相同的密钥
+	if again, _ := loadSessionKey(file); !bytes.Equal(again, key) {
+		t.Error("key changed after reload")
+	}
+	// 文件损坏时重新生成
+	os.WriteFile(file, []byte("broken"), 0o600)
+	if again, _ := loadSessionKey(file); len(again) != 32 || bytes.Equal(again, key) {
+		t.Errorf("broken key file: %x", again)
+	}
+}
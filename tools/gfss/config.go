@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"toolkit/utils"
+)
+
+const configName = "gfss.json"
+
+// 检查配置文件是否变化的间隔
+const configCheckInterval = 2 * time.Second
+
+// 配置文件格式，命令行参数优先于配置文件
+type Config struct {
+	Port        int64       `json:"port"`
+	WorkDir     string      `json:"workDir"`
+	Log         bool        `json:"log"`
+	Trash       bool        `json:"trash"`
+	MaxTextSize ByteSize    `json:"maxTextSize"`
+	MaxFileSize ByteSize    `json:"maxFileSize"`
+	Password    string      `json:"password"`
+	Users       []AuthUser  `json:"users"`
+	Tokens      []AuthToken `json:"tokens"`
+	TLS         bool        `json:"tls"`
+	Cert        string      `json:"cert"`
+	Key         string      `json:"key"`
+	Redirect    int64       `json:"redirect"`
+	// 通过 mDNS 发布服务，默认开启
+	MDNS bool `json:"mdns"`
+	// 重复上传处理方式：skip 返回已有文件，link 保存为硬链接，为空不去重
+	Dedup string `json:"dedup"`
+	// 服务模式：dropbox 只能上传，readonly 只能查看及下载，为空不限制，本机访问不受限制
+	Mode string `json:"mode"`
+	// 允许及禁止访问的 IP 或网段，如 192.168.1.0/24，allowIPs 为空时不限制，本机始终允许
+	AllowIPs []string `json:"allowIPs"`
+	DenyIPs  []string `json:"denyIPs"`
+	// 每秒字节数，分为单个客户端及所有客户端合计，0 不限速
+	UploadLimit        ByteSize `json:"uploadLimit"`
+	DownloadLimit      ByteSize `json:"downloadLimit"`
+	TotalUploadLimit   ByteSize `json:"totalUploadLimit"`
+	TotalDownloadLimit ByteSize `json:"totalDownloadLimit"`
+	// 文件保留天数，工作目录总大小上限（超出时从最旧的文件开始清理），上传的硬配额，0 不限制
+	RetentionDays int64    `json:"retentionDays"`
+	MaxTotalSize  ByteSize `json:"maxTotalSize"`
+	Quota         ByteSize `json:"quota"`
+
+	allowNets []netip.Prefix
+	denyNets  []netip.Prefix
+	auth      AuthConfig // 包括启动时生成的令牌
+	cookieKey []byte     // 会话 Cookie 的签名密钥，认证配置变化后随之改变
+}
+
+var confFile string
+var cliConf Config
+var usersFile string
+
+// 当前生效的配置，发布后不再修改，修改时复制一份后整体替换，confMux 串行化修改
+var confPtr atomic.Pointer[Config]
+var confMux sync.Mutex
+
+func init() {
+	confPtr.Store(defaultConfig())
+}
+
+func curConf() *Config {
+	return confPtr.Load()
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Port:        defaultPort,
+		MaxTextSize: defaultMaxTextSize,
+		MaxFileSize: defaultMaxFileSize,
+		MDNS:        true,
+	}
+}
+
+// 未指定时为程序目录下的 gfss.json
+func configPath() string {
+	if confFile != "" {
+		return confFile
+	}
+	return filepath.Join(filepath.Dir(execPath), configName)
+}
+
+// 读取配置文件并用命令行参数覆盖，配置文件不存在时使用默认值
+func loadConfig() (*Config, error) {
+	conf := defaultConfig()
+
+	file := configPath()
+	b, err := os.ReadFile(utils.NormalizePath(file))
+	if err == nil {
+		if err = json.Unmarshal(b, conf); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	} else if confFile != "" || !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "d":
+			conf.WorkDir = cliConf.WorkDir
+		case "p":
+			conf.Port = cliConf.Port
+		case "l":
+			conf.Log = cliConf.Log
+		case "t":
+			conf.Trash = cliConf.Trash
+		case "pwd":
+			conf.Password = cliConf.Password
+		case "dedup":
+			conf.Dedup = cliConf.Dedup
+		case "mode":
+			conf.Mode = cliConf.Mode
+		case "tls":
+			conf.TLS = cliConf.TLS
+		case "cert":
+			conf.Cert = cliConf.Cert
+		case "key":
+			conf.Key = cliConf.Key
+		case "redirect":
+			conf.Redirect = cliConf.Redirect
+		case "mdns":
+			conf.MDNS = cliConf.MDNS
+		}
+	})
+	if arg0 := flag.Arg(0); cliConf.WorkDir == "" && arg0 != "" && !strings.HasPrefix(arg0, "-") {
+		conf.WorkDir = arg0
+	}
+	if usersFile != "" {
+		auth, err := loadUsers(usersFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Users, conf.Tokens = auth.Users, auth.Tokens
+	}
+
+	if err = conf.check(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func (conf *Config) check() error {
+	if conf.Port <= 0 || conf.Port > 65535 {
+		return fmt.Errorf("无效端口: %d", conf.Port)
+	}
+	if conf.MaxTextSize <= 0 || conf.MaxFileSize <= 0 {
+		return errors.New("大小限制必须大于 0")
+	}
+	if conf.UploadLimit < 0 || conf.DownloadLimit < 0 ||
+		conf.TotalUploadLimit < 0 || conf.TotalDownloadLimit < 0 {
+		return errors.New("限速不能小于 0")
+	}
+	if conf.RetentionDays < 0 || conf.MaxTotalSize < 0 || conf.Quota < 0 {
+		return errors.New("保留天数及空间限制不能小于 0")
+	}
+	switch conf.Dedup {
+	case dedupOff, dedupSkip, dedupLink:
+	default:
+		return fmt.Errorf("无效去重方式: %s", conf.Dedup)
+	}
+	switch conf.Mode {
+	case modeNormal, modeDropbox, modeReadOnly:
+	default:
+		return fmt.Errorf("无效服务模式: %s", conf.Mode)
+	}
+	if err := checkAuthConfig(AuthConfig{Users: conf.Users, Tokens: conf.Tokens}); err != nil {
+		return err
+	}
+	var err error
+	if conf.allowNets, err = parsePrefixes(conf.AllowIPs); err != nil {
+		return err
+	}
+	if conf.denyNets, err = parsePrefixes(conf.DenyIPs); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range list {
+		prefix, err := parsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("无效 IP: %s", v)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// 支持单个 IP 或 CIDR 网段
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// 发布新配置，conf 之后不能再修改
+func applyConfig(conf *Config) {
+	conf.auth = AuthConfig{Users: conf.Users, Tokens: conf.Tokens}
+	// 保留启动时生成的令牌
+	if cliToken != nil {
+		conf.auth.Tokens = append(slices.Clone(conf.Tokens), *cliToken)
+	}
+	conf.cookieKey = cookieKey(conf.Password, conf.auth)
+	confPtr.Store(conf)
+}
+
+// 基于当前配置修改部分设置，如托盘切换回收站
+func updateConfig(fn func(conf *Config)) {
+	confMux.Lock()
+	defer confMux.Unlock()
+	conf := *curConf()
+	fn(&conf)
+	confPtr.Store(&conf)
+}
+
+// 重新加载配置，工作目录与托盘更改文件夹走相同流程，端口、日志及 HTTPS 需重启生效
+func reloadConfig() {
+	conf, err := loadConfig()
+	if err != nil {
+		log.Error("重新加载配置失败", err)
+		return
+	}
+	confMux.Lock()
+	old := curConf()
+	applyConfig(conf)
+	confMux.Unlock()
+
+	if conf.WorkDir != old.WorkDir && conf.WorkDir != "" {
+		if dir, ok := utils.IsDirExist(utils.NormalizePath(conf.WorkDir)); ok {
+			updateWorkDir(dir)
+			log.Infof("workDir:%s", workDir)
+		} else {
+			log.Error("工作目录不存在", conf.WorkDir)
+		}
+	}
+
+	var restart []string
+	if conf.Port != old.Port {
+		restart = append(restart, "port")
+	}
+	if conf.Log != old.Log {
+		restart = append(restart, "log")
+	}
+	if conf.MDNS != old.MDNS {
+		restart = append(restart, "mdns")
+	}
+	if conf.TLS != old.TLS || conf.Cert != old.Cert || conf.Key != old.Key || conf.Redirect != old.Redirect {
+		restart = append(restart, "tls")
+	}
+	if len(restart) > 0 {
+		log.Info("以下配置需重启后生效", strings.Join(restart, ","))
+	}
+
+	storageMgr.Kick()
+	if conf.Dedup != old.Dedup {
+		dedupIdx.Kick()
+	}
+	sseMgr.Broadcast("refresh", nil)
+	log.Info("配置已重新加载")
+}
+
+// 收到 SIGHUP 或配置文件变化时重新加载
+func watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+
+	stamp := configStamp()
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			if configStamp() == stamp {
+				continue
+			}
+		}
+		stamp = configStamp()
+		reloadConfig()
+	}
+}
+
+func configStamp() string {
+	files := []string{configPath(), usersFile}
+	var stamp string
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(utils.NormalizePath(file)); err == nil {
+			stamp += fmt.Sprintf("%d-%d|", info.ModTime().UnixNano(), info.Size())
+		} else {
+			stamp += "|"
+		}
+	}
+	return stamp
+}
+
+// 是否允许该 IP 访问，denyIPs 优先，未配置 allowIPs 时允许其他 IP，本机始终允许
+func ipAllowed(ip string) bool {
+	conf := curConf()
+	allowNets, denyNets := conf.allowNets, conf.denyNets
+	if utils.IsLocalIP(ip) || (len(allowNets) == 0 && len(denyNets) == 0) {
+		return true
+	}
+	addr, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if containsAddr(denyNets, addr) {
+		return false
+	}
+	return len(allowNets) == 0 || containsAddr(allowNets, addr)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// 字节大小，支持数字或 512K、100MB、3G 等写法
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	n, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(n)
+	return nil
+}
+
+func parseByteSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+	var shift uint
+	if i := strings.IndexAny(v, "KMGT"); i != -1 && i == len(v)-1 {
+		shift = 10 * uint(strings.IndexByte("KMGT", v[i])+1)
+		v = v[:i]
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("无效大小: %s", s)
+	}
+	return int64(f * float64(int64(1)<<shift)), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"1024", 1024, true},
+		{"512K", 512 << 10, true},
+		{"512kb", 512 << 10, true},
+		{"100MB", 100 << 20, true},
+		{"100MiB", 100 << 20, true},
+		{" 3G ", 3 << 30, true},
+		{"1.5G", 3 << 29, true},
+		{"2 T", 2 << 40, true},
+		{"10B", 10, true},
+		{"", 0, false},
+		{"K", 0, false},
+		{"-1M", 0, false},
+		{"10X", 0, false},
+		{"1KM", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d, ok %t", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestByteSizeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ByteSize
+		ok   bool
+	}{
+		{`1048576`, 1 << 20, true},
+		{`"1M"`, 1 << 20, true},
+		{`"200KB"`, 200 << 10, true},
+		{`"abc"`, 0, false},
+		{`1.5`, 0, false},
+		{`true`, 0, false},
+	}
+	for _, tt := range tests {
+		var got ByteSize
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("Unmarshal(%s) = %d, %v; want %d, ok %t", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+
+	var conf struct {
+		Quota ByteSize `json:"quota"`
+	}
+	if err := json.Unmarshal([]byte(`{"quota":"2G"}`), &conf); err != nil || conf.Quota != 2<<30 {
+		t.Errorf("quota = %d, %v", conf.Quota, err)
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"192.168.1.5", "192.168.1.5/32", true},
+		{"192.168.1.5/24", "192.168.1.0/24", true},
+		{"::ffff:10.0.0.1", "10.0.0.1/32", true},
+		{"fe80::1", "fe80::1/128", true},
+		{"2001:db8::1/32", "2001:db8::/32", true},
+		{"10.0.0.0/33", "", false},
+		{"10.0.0", "", false},
+		{"host.lan", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parsePrefix(tt.in)
+		if (err == nil) != tt.ok || (tt.ok && got.String() != tt.want) {
+			t.Errorf("parsePrefix(%q) = %v, %v; want %s, ok %t", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	old := curConf()
+	t.Cleanup(func() { confPtr.Store(old) })
+
+	tests := []struct {
+		allow, deny []string
+		ip          string
+		want        bool
+	}{
+		{nil, nil, "8.8.8.8", true},
+		{[]string{"192.168.1.0/24"}, nil, "192.168.1.20", true},
+		{[]string{"192.168.1.0/24"}, nil, "192.168.2.20", false},
+		{[]string{"192.168.1.0/24"}, nil, "127.0.0.1", true}, // 本机始终允许
+		{[]string{"192.168.1.0/24"}, nil, "[::1]", true},
+		{nil, []string{"10.0.0.0/8"}, "10.1.2.3", false},
+		{nil, []string{"10.0.0.0/8"}, "172.16.0.1", true},
+		{[]string{"10.0.0.0/8"}, []string{"10.0.0.5"}, "10.0.0.5", false}, // 拒绝优先
+		{[]string{"10.0.0.0/8"}, []string{"10.0.0.5"}, "10.0.0.6", true},
+		{[]string{"fd00::/8"}, nil, "[fd00::1]", true},
+		{nil, []string{"10.0.0.1"}, "[::ffff:10.0.0.1]", false},
+		{[]string{"10.0.0.0/8"}, nil, "invalid", false},
+	}
+	for _, tt := range tests {
+		conf := defaultConfig()
+		conf.AllowIPs, conf.DenyIPs = tt.allow, tt.deny
+		if err := conf.check(); err != nil {
+			t.Fatal(err)
+		}
+		applyConfig(conf)
+		if got := ipAllowed(tt.ip); got != tt.want {
+			t.Errorf("allow %v deny %v: ipAllowed(%q) = %t, want %t", tt.allow, tt.deny, tt.ip, got, tt.want)
+		}
+	}
+
+	conf := defaultConfig()
+	conf.DenyIPs = []string{"10.0.0.0/40"}
+	if conf.check() == nil {
+		t.Error("invalid deny rule accepted")
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"toolkit/utils"
+
+	"github.com/hymkor/trash-go"
+	"golang.org/x/net/webdav"
+)
+
+const davPrefix = "/dav"
+
+var davHandler = &webdav.Handler{
+	Prefix:     davPrefix,
+	FileSystem: davFS{},
+	LockSystem: webdav.NewMemLS(),
+}
+
+type davClientKey struct{}
+
+// WebDAV 挂载工作目录，与网页共用路径校验、回收站及下载跟踪
+func dav(c *utils.Ctx) {
+	var r = c.R.WithContext(context.WithValue(c.R.Context(), davClientKey{}, c.ID))
+	body, doneR := bwMgr.Reader(c, r.Body)
+	defer doneR()
+	r.Body = body
+	switch r.Method {
+	case http.MethodPut:
+		davPut(c, body)
+		return
+	case "COPY":
+		davCopy(c)
+		return
+	}
+	w, doneW := bwMgr.Writer(c)
+	defer doneW()
+	// 只有读取文件的响应计入下载字节数
+	if r.Method == http.MethodGet {
+		w = metricsMgr.Writer(w)
+	}
+	now := time.Now()
+	sw := &statWriter{ResponseWriter: w, status: http.StatusOK}
+	davHandler.ServeHTTP(sw, r)
+
+	// 只记录文件内容的读写及删除，目录浏览及锁等请求不记录
+	var op string
+	var size int64
+	switch r.Method {
+	case http.MethodGet:
+		op, size = "download", sw.written
+	case http.MethodDelete:
+		op = "delete"
+		if curConf().Trash {
+			op = "trash"
+		}
+	default:
+		return
+	}
+	rel := strings.Trim(strings.TrimPrefix(r.URL.Path, davPrefix), "/")
+	audit(c, op, rel, size, time.Since(now), sw.status, "")
+}
+
+// 上传与网页相同，先写入临时文件，边写边检查大小限制及配额并计算摘要，完成后覆盖目标文件
+func davPut(c *utils.Ctx, body io.Reader) {
+	var now = time.Now()
+	maxFileSize := int64(curConf().MaxFileSize)
+	raw := strings.TrimPrefix(c.R.URL.Path, davPrefix)
+	fp, rel, err := resolvePath(raw)
+	if err != nil || rel == "" || isProtectedPath(fp) {
+		writeErrorRsp(c, http.StatusForbidden, "非法文件路径", err, raw)
+		return
+	}
+	if st, err := os.Stat(filepath.Dir(fp)); err != nil || !st.IsDir() {
+		writeErrorRsp(c, http.StatusConflict, "文件夹不存在", err, rel)
+		return
+	}
+	info, err := os.Lstat(fp)
+	exist := err == nil
+	if exist && !info.Mode().IsRegular() {
+		writeErrorRsp(c, http.StatusMethodNotAllowed, "非文件路径", nil, rel)
+		return
+	}
+	if exist && dlTracker.IsDownloading(rel) {
+		writeErrorRsp(c, http.StatusLocked, "文件正在被下载", nil, rel)
+		return
+	}
+
+	res, ok := storageMgr.Reserve(c.R.ContentLength)
+	if !ok {
+		writeErrorRsp(c, http.StatusInsufficientStorage, "超出存储配额", nil, utils.FormatBytesIEC(int64(curConf().Quota)))
+		audit(c, "upload", rel, c.R.ContentLength, 0, http.StatusInsufficientStorage, "")
+		return
+	}
+	defer res.Release()
+
+	s, out, err := tfTracker.Create(filepath.Dir(fp), filepath.Base(fp), -1)
+	if err != nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "创建临时文件失败", err, rel)
+		return
+	}
+	defer tfTracker.Remove(s.ID)
+
+	h := sha256.New()
+	buf := uploadBufPool.Get().([]byte)
+	n, err := io.CopyBuffer(io.MultiWriter(out, h), io.LimitReader(res.Reader(metricsMgr.Reader(body)), maxFileSize+1), buf)
+	uploadBufPool.Put(buf)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	status := http.StatusCreated
+	if exist {
+		status = http.StatusNoContent
+	}
+	var sum string
+	switch {
+	case n > maxFileSize:
+		status = http.StatusRequestEntityTooLarge
+		writeErrorRsp(c, status, fmt.Sprintf("文件超出%s限制", utils.FormatBytesIEC(maxFileSize)), nil, rel)
+	case errors.Is(err, errQuotaExceeded):
+		status = http.StatusInsufficientStorage
+		writeErrorRsp(c, status, "超出存储配额", nil, utils.FormatBytesIEC(int64(curConf().Quota)))
+	case err != nil:
+		status = http.StatusInternalServerError
+		writeErrorRsp(c, status, "保存文件失败", err, rel)
+	default:
+		if err = os.Rename(s.path, fp); err != nil {
+			status = http.StatusInternalServerError
+			writeErrorRsp(c, status, "重命名文件失败", err, rel)
+			break
+		}
+		res.Commit(n)
+		sum = hex.EncodeToString(h.Sum(nil))
+		recordUpload(c, fp, sum)
+		c.Info("u", rel, utils.FormatBytesIEC(n))
+		c.W.WriteHeader(status)
+	}
+	audit(c, "upload", rel, n, time.Since(now), status, sum)
+}
+
+// COPY 与网页复制相同，先复制到同一文件夹的临时名称，复制的数据计入配额并记录摘要，完成后改为目标名称
+// 目标已存在且 Overwrite 不为 F 时替换，Depth 为 0 时只复制文件夹本身
+func davCopy(c *utils.Ctx) {
+	raw := strings.TrimPrefix(c.R.URL.Path, davPrefix)
+	src, rel, err := resolvePath(raw)
+	if err != nil || rel == "" || isProtectedPath(src) {
+		writeErrorRsp(c, http.StatusForbidden, "非法文件路径", err, raw)
+		return
+	}
+	info, err := os.Lstat(src)
+	if err != nil || utils.IsIgnoreFile(info) || (!info.IsDir() && !info.Mode().IsRegular()) {
+		writeErrorRsp(c, http.StatusNotFound, "文件不存在", err, rel)
+		return
+	}
+	depth := c.R.Header.Get("Depth")
+	if depth != "" && depth != "0" && !strings.EqualFold(depth, "infinity") {
+		writeErrorRsp(c, http.StatusBadRequest, "无效 Depth", nil, depth)
+		return
+	}
+
+	u, err := url.Parse(c.R.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		writeErrorRsp(c, http.StatusBadRequest, "无效目标路径", err, c.R.Header.Get("Destination"))
+		return
+	}
+	if (u.Host != "" && u.Host != c.R.Host) || !strings.HasPrefix(u.Path, davPrefix+"/") {
+		writeErrorRsp(c, http.StatusBadGateway, "无效目标路径", nil, c.R.Header.Get("Destination"))
+		return
+	}
+	dst, dstRel, err := resolvePath(strings.TrimPrefix(u.Path, davPrefix))
+	if err != nil || dstRel == "" || isProtectedPath(dst) || dst == src || isSubPath(src, dst) {
+		writeErrorRsp(c, http.StatusForbidden, "非法文件路径", err, u.Path)
+		return
+	}
+	dir := filepath.Dir(dst)
+	if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+		writeErrorRsp(c, http.StatusConflict, "文件夹不存在", err, dstRel)
+		return
+	}
+	dstInfo, err := os.Lstat(dst)
+	exist := err == nil
+	if exist && c.R.Header.Get("Overwrite") == "F" {
+		writeErrorRsp(c, http.StatusPreconditionFailed, "目标已存在", nil, dstRel)
+		return
+	}
+	if exist && (isProtectedPath(dst) || dlTracker.IsDownloading(dstRel) ||
+		(dstInfo.IsDir() && tfTracker.IsUploading(dst))) {
+		writeErrorRsp(c, http.StatusLocked, "目标正在使用", nil, dstRel)
+		return
+	}
+
+	// 与上传相同的单个文件大小限制
+	recursive := !info.IsDir() || depth != "0"
+	if recursive {
+		maxFileSize := int64(curConf().MaxFileSize)
+		err = filepath.WalkDir(src, func(fp string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			if fi, err := d.Info(); err == nil && fi.Size() > maxFileSize {
+				return fmt.Errorf("文件超出%s限制", utils.FormatBytesIEC(maxFileSize))
+			}
+			return nil
+		})
+		if err != nil {
+			writeErrorRsp(c, http.StatusRequestEntityTooLarge, err.Error(), nil, rel)
+			return
+		}
+	}
+
+	dlTracker.Start(rel)
+	defer dlTracker.End(rel)
+
+	res, ok := storageMgr.Reserve(0)
+	if !ok {
+		writeErrorRsp(c, http.StatusInsufficientStorage, "超出存储配额", nil, utils.FormatBytesIEC(int64(curConf().Quota)))
+		return
+	}
+	defer res.Release()
+
+	tmp, err := stagePath(dir)
+	if err == nil && info.IsDir() {
+		err = os.Mkdir(tmp, 0o755)
+	}
+	var sums map[string]string
+	var size int64
+	if err == nil && recursive {
+		sums, size, err = copyTree(src, tmp, res)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		if errors.Is(err, errQuotaExceeded) {
+			writeErrorRsp(c, http.StatusInsufficientStorage, "超出存储配额", nil, utils.FormatBytesIEC(int64(curConf().Quota)))
+			return
+		}
+		writeErrorRsp(c, http.StatusInternalServerError, "复制文件失败", err, rel)
+		return
+	}
+
+	dirWatcher.Lock()
+	if exist && dstInfo.IsDir() {
+		err = os.RemoveAll(dst)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		dirWatcher.Unlock()
+		os.RemoveAll(tmp)
+		writeErrorRsp(c, http.StatusInternalServerError, "复制文件失败", err, dstRel)
+		return
+	}
+	res.Commit(size)
+	if exist {
+		dedupIdx.Remove(dstRel)
+	}
+	dirWatcher.Added(dstRel)
+	dirWatcher.Unlock()
+
+	for sub, sum := range sums {
+		indexFile(filepath.Join(dst, filepath.FromSlash(sub)), sum)
+	}
+	c.Info("c", rel, dstRel)
+	if exist {
+		c.W.WriteHeader(http.StatusNoContent)
+	} else {
+		c.W.WriteHeader(http.StatusCreated)
+	}
+}
+
+// 按 WebDAV 方法划分所需权限
+func davPerm(method string) int {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return permRead
+	case http.MethodDelete, "MOVE":
+		return permRead | permUpload | permDelete
+	default:
+		return permRead | permUpload
+	}
+}
+
+func davLog(ctx context.Context, params ...any) {
+	c := utils.Ctx{}
+	c.ID, _ = ctx.Value(davClientKey{}).(string)
+	c.Log(1, "inf", params...)
+}
+
+// 临时文件及越界路径对客户端表现为不存在
+func davResolve(name string) (string, string, error) {
+	fp, rel, err := resolvePath(name)
+	if errors.Is(err, errInvalidPath) {
+		return "", "", os.ErrNotExist
+	}
+	return fp, rel, err
+}
+
+type davFS struct{}
+
+func (davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	fp, rel, err := davResolve(name)
+	if err != nil {
+		return err
+	}
+	if err = os.Mkdir(fp, perm); err != nil {
+		return err
+	}
+	davLog(ctx, "m", rel)
+	return nil
+}
+
+// 写入只能经过 davPut 及 davCopy，以检查大小限制及配额并记录摘要，
+// PROPPATCH 以读写方式打开但不写入文件内容，按只读打开
+func (davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	flag = os.O_RDONLY
+	fp, rel, err := davResolve(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(fp, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	df := &davFile{File: f, dir: fp}
+	// 与网页下载一样登记，读取期间不允许删除或移动
+	if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+		df.rel = rel
+		dlTracker.Start(rel)
+	}
+	return df, nil
+}
+
+func (davFS) RemoveAll(ctx context.Context, name string) error {
+	fp, rel, err := davResolve(name)
+	if err != nil {
+		return err
+	}
+	// MOVE 覆盖目标时同样先删除，不能删除有文件正在上传的文件夹
+	if isProtectedPath(fp) || dlTracker.IsDownloading(rel) || tfTracker.IsUploading(fp) {
+		return os.ErrPermission
+	}
+	if curConf().Trash {
+		if err = trash.Throw(fp); err != nil {
+			return err
+		}
+		davLog(ctx, "t", rel)
+		dedupIdx.Remove(rel)
+		return nil
+	}
+	if err = os.RemoveAll(fp); err != nil {
+		return err
+	}
+	davLog(ctx, "d", rel)
+	dedupIdx.Remove(rel)
+	return nil
+}
+
+func (davFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, oldRel, err := davResolve(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, newRel, err := davResolve(newName)
+	if err != nil {
+		return err
+	}
+	// 与网页移动相同的检查，有文件正在上传的文件夹既不能移动也不能被覆盖
+	if isProtectedPath(oldPath) || isProtectedPath(newPath) || dlTracker.IsDownloading(oldRel) ||
+		tfTracker.IsUploading(oldPath) || tfTracker.IsUploading(newPath) {
+		return os.ErrPermission
+	}
+
+	dirWatcher.Lock()
+	defer dirWatcher.Unlock()
+	if err = os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	davLog(ctx, "r", oldRel, newRel)
+	// 同步去重索引、分享链接及目录快照
+	dirWatcher.Renamed(oldRel, newRel)
+	return nil
+}
+
+func (davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fp, _, err := davResolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(fp)
+}
+
+// 列目录时隐藏临时文件、程序文件及隐藏文件，与网页列表保持一致
+type davFile struct {
+	*os.File
+	dir string
+	rel string // 登记为下载中的文件，关闭时注销
+}
+
+func (f *davFile) Close() error {
+	if f.rel != "" {
+		dlTracker.End(f.rel)
+		f.rel = ""
+	}
+	return f.File.Close()
+}
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	list := infos[:0]
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasSuffix(name, tmpSuffix) ||
+			(!info.Mode().IsRegular() && !info.IsDir()) ||
+			utils.IsIgnoreFile(info) ||
+			isSidecarFile(filepath.Join(f.dir, name)) {
+			continue
+		}
+		list = append(list, info)
+	}
+	return list, err
+}
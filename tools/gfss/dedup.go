@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"toolkit/utils"
+)
+
+const (
+	dedupOff  = ""
+	dedupSkip = "skip" // 不保存，直接返回已有文件
+	dedupLink = "link" // 以硬链接方式保存
+)
+
+// 索引记录数量上限
+const maxDedupEntries = 100000
+
+type dedupEntry struct {
+	Path    string `json:"path"` // 相对工作目录，以 / 分隔
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"` // 纳秒时间戳
+	SHA256  string `json:"sha256"`
+}
+
+type dedupData struct {
+	Root    string       `json:"root"`
+	Entries []dedupEntry `json:"entries"`
+}
+
+// 上传文件的摘要索引，持久化到程序所在目录，用于查找内容相同的文件
+// 删除、移入回收站及重命名时同步更新，查找时再次核对文件大小及修改时间
+// 开启去重后在后台扫描工作目录，补充索引中没有的文件
+type DedupIndex struct {
+	mux     sync.Mutex
+	file    string
+	root    string
+	entries map[string]dedupEntry // 以相对路径为键
+	sums    map[string][]string   // 摘要对应的相对路径
+	saver   *DelayedSaver
+	kick    chan struct{}
+}
+
+func NewDedupIndex(file string) *DedupIndex {
+	t := &DedupIndex{
+		file:    file,
+		entries: make(map[string]dedupEntry),
+		sums:    make(map[string][]string),
+		kick:    make(chan struct{}, 1),
+	}
+	t.saver = NewDelayedSaver("去重索引", t.save)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("读取去重索引失败", err)
+		}
+		return t
+	}
+	var data dedupData
+	if err = json.Unmarshal(b, &data); err != nil {
+		log.Error("读取去重索引失败", file, err)
+		return t
+	}
+	t.root = data.Root
+	for _, e := range data.Entries {
+		t.put(e)
+	}
+	return t
+}
+
+func dedupFile() string {
+	return filepath.Join(filepath.Dir(execPath), "gfss_dedup.json")
+}
+
+// 开启去重时启动后及每隔 janitorInterval 扫描一次，配置或工作目录变化时立即扫描
+func (t *DedupIndex) Run() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		if curConf().Dedup != dedupOff {
+			t.scan()
+		}
+		select {
+		case <-ticker.C:
+		case <-t.kick:
+		}
+	}
+}
+
+func (t *DedupIndex) Kick() {
+	select {
+	case t.kick <- struct{}{}:
+	default:
+	}
+}
+
+// 计算索引中没有或已变化的文件摘要，优先使用摘要缓存
+func (t *DedupIndex) scan() {
+	root := workDir
+	files, _, _ := scanFiles(root)
+	var count int
+	for _, f := range files {
+		if workDir != root || curConf().Dedup == dedupOff {
+			return
+		}
+		t.mux.Lock()
+		e, ok := t.entries[f.rel]
+		full := len(t.entries) >= maxDedupEntries
+		t.mux.Unlock()
+		if ok && e.Size == f.size && e.ModTime == f.modTime.UnixNano() {
+			continue
+		}
+		if full {
+			break
+		}
+		info, err := os.Stat(f.fp)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		sum, err := hashCache.Sum(f.fp, info)
+		if err != nil {
+			continue
+		}
+		t.Add(f.fp, info, sum)
+		count++
+	}
+	if count > 0 {
+		log.Infof("去重索引新增 %d 个文件", count)
+	}
+}
+
+// 工作目录变化后索引作废
+func (t *DedupIndex) checkRoot() {
+	if t.root != workDir {
+		t.root = workDir
+		clear(t.entries)
+		clear(t.sums)
+	}
+}
+
+func (t *DedupIndex) put(e dedupEntry) {
+	t.del(e.Path)
+	t.entries[e.Path] = e
+	t.sums[e.SHA256] = append(t.sums[e.SHA256], e.Path)
+}
+
+func (t *DedupIndex) del(rel string) {
+	e, ok := t.entries[rel]
+	if !ok {
+		return
+	}
+	delete(t.entries, rel)
+	paths := slices.DeleteFunc(t.sums[e.SHA256], func(p string) bool { return p == rel })
+	if len(paths) == 0 {
+		delete(t.sums, e.SHA256)
+	} else {
+		t.sums[e.SHA256] = paths
+	}
+}
+
+func (t *DedupIndex) valid(e dedupEntry) (string, bool) {
+	fp := filepath.Join(t.root, filepath.FromSlash(e.Path))
+	info, err := os.Stat(fp)
+	if err != nil || !info.Mode().IsRegular() ||
+		info.Size() != e.Size || info.ModTime().UnixNano() != e.ModTime {
+		return "", false
+	}
+	return fp, true
+}
+
+// 查找内容相同的文件，返回其绝对路径
+func (t *DedupIndex) Lookup(sum string, size int64) (string, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.checkRoot()
+
+	for _, rel := range slices.Clone(t.sums[sum]) {
+		e := t.entries[rel]
+		if e.Size != size {
+			continue
+		}
+		if fp, ok := t.valid(e); ok {
+			return fp, true
+		}
+		t.del(rel)
+		t.saver.Schedule()
+	}
+	return "", false
+}
+
+func (t *DedupIndex) Add(fp string, info os.FileInfo, sum string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.checkRoot()
+
+	rel, err := filepath.Rel(t.root, fp)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+	if _, ok := t.entries[rel]; !ok && len(t.entries) >= maxDedupEntries {
+		for k, e := range t.entries {
+			if _, ok := t.valid(e); !ok {
+				t.del(k)
+			}
+		}
+		if len(t.entries) >= maxDedupEntries {
+			return
+		}
+	}
+	t.put(dedupEntry{
+		Path:    rel,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		SHA256:  sum,
+	})
+	t.saver.Schedule()
+}
+
+// 移除文件或文件夹下的所有记录
+func (t *DedupIndex) Remove(rel string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.checkRoot()
+
+	// 文件只有一条记录，无需遍历
+	if _, ok := t.entries[rel]; ok {
+		t.del(rel)
+		t.saver.Schedule()
+		return
+	}
+	var changed bool
+	for k := range t.entries {
+		if strings.HasPrefix(k, rel+"/") {
+			t.del(k)
+			changed = true
+		}
+	}
+	if changed {
+		t.saver.Schedule()
+	}
+}
+
+func (t *DedupIndex) Rename(from, to string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.checkRoot()
+
+	var moved []dedupEntry
+	if e, ok := t.entries[from]; ok {
+		moved = append(moved, e)
+	} else {
+		for k, e := range t.entries {
+			if strings.HasPrefix(k, from+"/") {
+				moved = append(moved, e)
+			}
+		}
+	}
+	for _, e := range moved {
+		t.del(e.Path)
+		e.Path = to + strings.TrimPrefix(e.Path, from)
+		t.put(e)
+	}
+	if len(moved) > 0 {
+		t.saver.Schedule()
+	}
+}
+
+// 程序退出前保存尚未写入的修改
+func (t *DedupIndex) Close() {
+	t.saver.Flush()
+}
+
+// 在锁内复制记录，锁外序列化及写入
+func (t *DedupIndex) save() error {
+	t.mux.Lock()
+	data := dedupData{Root: t.root, Entries: make([]dedupEntry, 0, len(t.entries))}
+	for _, e := range t.entries {
+		data.Entries = append(data.Entries, e)
+	}
+	t.mux.Unlock()
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tmp := t.file + tmpSuffix
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.file)
+}
+
+// 开启去重时查找内容相同的已有文件：skip 模式直接返回已有文件，
+// link 模式在目标文件夹中创建指向已有文件的硬链接，创建失败时按普通上传保存
+// 投递箱模式的访客总是保存新文件，避免通过去重结果探测其他访客上传的文件
+func dedupUpload(c *utils.Ctx, dir, fname, sum string, size int64) (finalPath string, mode string) {
+	mode = curConf().Dedup
+	if mode == dedupOff || clientMode(c) == modeDropbox {
+		return "", dedupOff
+	}
+	existing, ok := dedupIdx.Lookup(sum, size)
+	if !ok {
+		return "", dedupOff
+	}
+	if mode == dedupSkip {
+		return existing, mode
+	}
+
+	finalPath, err := reserveFileName(dir, fname)
+	if err != nil {
+		return "", dedupOff
+	}
+	os.Remove(finalPath)
+	if err = os.Link(existing, finalPath); err != nil {
+		log.Error("创建硬链接失败", err)
+		return "", dedupOff
+	}
+	return finalPath, mode
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"toolkit/utils"
+)
+
+func TestDedupIndex(t *testing.T) {
+	dir := t.TempDir()
+	old := workDir
+	workDir = dir
+	t.Cleanup(func() { workDir = old })
+
+	write := func(rel, data string) (string, os.FileInfo) {
+		fp := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := os.Stat(fp)
+		return fp, info
+	}
+
+	idx := NewDedupIndex(filepath.Join(t.TempDir(), "dedup.json"))
+	fa, ia := write("a/x.txt", "hello")
+	fb, ib := write("b.txt", "hello")
+	idx.Add(fa, ia, "sum1")
+	idx.Add(fb, ib, "sum1")
+
+	if fp, ok := idx.Lookup("sum1", 5); !ok || (fp != fa && fp != fb) {
+		t.Fatalf("Lookup = %q %t", fp, ok)
+	}
+	if _, ok := idx.Lookup("sum1", 6); ok {
+		t.Error("Lookup matched a different size")
+	}
+	if _, ok := idx.Lookup("sum2", 5); ok {
+		t.Error("Lookup matched a different sum")
+	}
+
+	// 重命名文件夹后记录随之移动
+	if err := os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "c")); err != nil {
+		t.Fatal(err)
+	}
+	idx.Rename("a", "c")
+	idx.Remove("b.txt")
+	if fp, ok := idx.Lookup("sum1", 5); !ok || fp != filepath.Join(dir, "c", "x.txt") {
+		t.Fatalf("Lookup after rename = %q %t", fp, ok)
+	}
+
+	// 文件被修改后失效并从索引中移除
+	write("c/x.txt", "world")
+	if _, ok := idx.Lookup("sum1", 5); ok {
+		t.Error("Lookup returned a modified file")
+	}
+	if len(idx.entries) != 0 || len(idx.sums) != 0 {
+		t.Errorf("stale entries left: %v %v", idx.entries, idx.sums)
+	}
+
+	// 保存后重新加载
+	_, id := write("d.txt", "data")
+	idx.Add(filepath.Join(dir, "d.txt"), id, "sum3")
+	idx.Close()
+	idx = NewDedupIndex(idx.file)
+	if _, ok := idx.Lookup("sum3", 4); !ok {
+		t.Error("entry lost after reload")
+	}
+}
+
+func TestDedupUploadDropbox(t *testing.T) {
+	dir := setupUploadTest(t)
+	old := curConf()
+	t.Cleanup(func() { confPtr.Store(old) })
+	updateConfig(func(conf *Config) { conf.Dedup, conf.Mode = dedupSkip, modeDropbox })
+
+	fp := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(fp, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(fp)
+	dedupIdx.Add(fp, info, "sum1")
+
+	// 投递箱访客总是保存新文件，本机不受模式限制
+	tests := []struct {
+		ip   string
+		mode string
+	}{
+		{"10.0.0.9", dedupOff},
+		{"127.0.0.1", dedupSkip},
+	}
+	for _, tt := range tests {
+		c := &utils.Ctx{ID: tt.ip}
+		if got, mode := dedupUpload(c, dir, "b.txt", "sum1", 5); mode != tt.mode || (mode == dedupSkip && got != fp) {
+			t.Errorf("%s: dedupUpload = %q %q, want mode %q", tt.ip, got, mode, tt.mode)
+		}
+	}
+}
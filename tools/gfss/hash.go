@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"toolkit/utils"
+)
+
+// 缓存的文件数量上限
+const maxHashCache = 10000
+
+// 限制同时计算摘要的文件数量
+var hashSem = make(chan struct{}, 2)
+
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// 文件 SHA-256 缓存，文件大小或修改时间变化后失效
+type HashCache struct {
+	mux     sync.Mutex
+	entries map[string]hashEntry
+}
+
+func NewHashCache() *HashCache {
+	return &HashCache{
+		entries: make(map[string]hashEntry),
+	}
+}
+
+func (t *HashCache) Get(fp string, info os.FileInfo) (string, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	e, ok := t.entries[fp]
+	if !ok || e.size != info.Size() || !e.modTime.Equal(info.ModTime()) {
+		return "", false
+	}
+	return e.sum, true
+}
+
+func (t *HashCache) Put(fp string, info os.FileInfo, sum string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if len(t.entries) >= maxHashCache {
+		// 先清理已失效的记录，仍然已满时全部清空
+		for k, e := range t.entries {
+			if st, err := os.Stat(k); err != nil || st.Size() != e.size || !st.ModTime().Equal(e.modTime) {
+				delete(t.entries, k)
+			}
+		}
+		if len(t.entries) >= maxHashCache {
+			clear(t.entries)
+		}
+	}
+	t.entries[fp] = hashEntry{size: info.Size(), modTime: info.ModTime(), sum: sum}
+}
+
+// 获取文件摘要，缓存失效时重新计算
+func (t *HashCache) Sum(fp string, info os.FileInfo) (string, error) {
+	if sum, ok := t.Get(fp, info); ok {
+		return sum, nil
+	}
+	sum, err := sumFile(fp)
+	if err != nil {
+		return "", err
+	}
+	// 计算期间文件被修改时不缓存
+	if st, err := os.Stat(fp); err == nil && st.Size() == info.Size() && st.ModTime().Equal(info.ModTime()) {
+		t.Put(fp, info, sum)
+	}
+	return sum, nil
+}
+
+func sumFile(fp string) (string, error) {
+	hashSem <- struct{}{}
+	defer func() { <-hashSem }()
+
+	f, err := os.Open(fp)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	buf := uploadBufPool.Get().([]byte)
+	_, err = io.CopyBuffer(h, f, buf)
+	uploadBufPool.Put(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 校验客户端提供的 SHA-256，返回小写十六进制，为空表示未提供
+func parseSHA256(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimPrefix(s, "sha256:")
+	if s == "" {
+		return "", true
+	}
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", false
+	}
+	return s, true
+}
+
+type HashRsp struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// 文件 SHA-256，供下载方校验
+func fileHash(c *utils.Ctx) {
+	fileName, err := url.PathUnescape(strings.TrimPrefix(c.R.URL.Path, "/hash/"))
+	if err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", nil, fileName)
+		return
+	}
+	fp, rel, err := resolvePath(fileName)
+	if err != nil || rel == "" {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, fileName)
+		return
+	}
+	info, err := os.Stat(fp)
+	if err != nil || !info.Mode().IsRegular() || utils.IsIgnoreFile(info) {
+		writeErrorRsp(c, http.StatusNotFound, "文件不存在", err, rel)
+		return
+	}
+
+	dlTracker.Start(rel)
+	sum, err := hashCache.Sum(fp, info)
+	dlTracker.End(rel)
+	if err != nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "计算摘要失败", err, rel)
+		return
+	}
+
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.W.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(c.W).Encode(HashRsp{Path: rel, Size: info.Size(), SHA256: sum})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+	"toolkit/utils"
+)
+
+// 每次限速等待处理的最大字节数，避免大缓冲区一次等待过久
+const limitChunk = 32 * 1024
+
+// 客户端没有进行中的传输超过该时长后才移除其令牌桶，连续的短请求仍受限速
+const limiterIdleTTL = time.Minute
+
+// 令牌桶，最多积累 1 秒的流量
+type RateLimiter struct {
+	mux    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// 预扣 n 字节的令牌，令牌不足时等待补足，rate 每次传入以便配置修改后立即生效
+func (l *RateLimiter) Wait(ctx context.Context, rate int64, n int) error {
+	if rate <= 0 {
+		return nil
+	}
+	var now = time.Now()
+	l.mux.Lock()
+	if l.last.IsZero() {
+		l.tokens = float64(rate)
+	} else {
+		l.tokens = min(float64(rate), l.tokens+now.Sub(l.last).Seconds()*float64(rate))
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+	l.mux.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type clientLimiter struct {
+	up, down RateLimiter
+	refs     int
+	lastUsed time.Time // 最后一次传输结束的时间
+}
+
+// 按客户端及全局限制上传、下载速度，本机访问不限速
+type BandwidthManager struct {
+	mux      sync.Mutex
+	up, down RateLimiter
+	clients  map[string]*clientLimiter
+	swept    time.Time
+}
+
+func NewBandwidthManager() *BandwidthManager {
+	return &BandwidthManager{
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+func (t *BandwidthManager) acquire(ip string) *clientLimiter {
+	var now = time.Now()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if now.Sub(t.swept) > limiterIdleTTL {
+		t.swept = now
+		for k, cl := range t.clients {
+			if cl.refs <= 0 && now.Sub(cl.lastUsed) > limiterIdleTTL {
+				delete(t.clients, k)
+			}
+		}
+	}
+	cl, ok := t.clients[ip]
+	if !ok {
+		cl = &clientLimiter{}
+		t.clients[ip] = cl
+	}
+	cl.refs++
+	return cl
+}
+
+func (t *BandwidthManager) release(ip string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	// 保留空闲的令牌桶，之后的请求继承已消耗的令牌，超时后在 acquire 中移除
+	if cl, ok := t.clients[ip]; ok {
+		cl.refs--
+		cl.lastUsed = time.Now()
+	}
+}
+
+func (t *BandwidthManager) waitUp(ctx context.Context, cl *clientLimiter, n int) error {
+	conf := curConf()
+	if err := cl.up.Wait(ctx, int64(conf.UploadLimit), n); err != nil {
+		return err
+	}
+	return t.up.Wait(ctx, int64(conf.TotalUploadLimit), n)
+}
+
+func (t *BandwidthManager) waitDown(ctx context.Context, cl *clientLimiter, n int) error {
+	conf := curConf()
+	if err := cl.down.Wait(ctx, int64(conf.DownloadLimit), n); err != nil {
+		return err
+	}
+	return t.down.Wait(ctx, int64(conf.TotalDownloadLimit), n)
+}
+
+// 包装请求体限制上传速度，传输结束后调用返回的函数
+func (t *BandwidthManager) Reader(c *utils.Ctx, body io.ReadCloser) (io.ReadCloser, func()) {
+	if conf := curConf(); (conf.UploadLimit <= 0 && conf.TotalUploadLimit <= 0) || utils.IsLocalIP(c.ID) {
+		return body, func() {}
+	}
+	ip := c.ID
+	r := &limitedReader{ReadCloser: body, ctx: c.R.Context(), mgr: t, cl: t.acquire(ip)}
+	return r, func() { t.release(ip) }
+}
+
+// 包装响应限制下载速度，传输结束后调用返回的函数
+func (t *BandwidthManager) Writer(c *utils.Ctx) (http.ResponseWriter, func()) {
+	if conf := curConf(); (conf.DownloadLimit <= 0 && conf.TotalDownloadLimit <= 0) || utils.IsLocalIP(c.ID) {
+		return c.W, func() {}
+	}
+	ip := c.ID
+	w := &limitedWriter{ResponseWriter: c.W, ctx: c.R.Context(), mgr: t, cl: t.acquire(ip)}
+	return w, func() { t.release(ip) }
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	ctx context.Context
+	mgr *BandwidthManager
+	cl  *clientLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitChunk {
+		p = p[:limitChunk]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := r.mgr.waitUp(r.ctx, r.cl, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+type limitedWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+	mgr *BandwidthManager
+	cl  *clientLimiter
+}
+
+func (w *limitedWriter) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		chunk := p[:min(len(p), limitChunk)]
+		if err = w.mgr.waitDown(w.ctx, w.cl, len(chunk)); err != nil {
+			return
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return
+}
+
+func (w *limitedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var l RateLimiter
+	// 不限速时不消耗令牌
+	if err := l.Wait(canceled, 0, 1<<20); err != nil || !l.last.IsZero() {
+		t.Fatalf("unlimited: err = %v, last = %v", err, l.last)
+	}
+
+	const rate = 1000
+	// 初始令牌为 1 秒的流量，之内不需要等待
+	steps := []struct {
+		n    int
+		wait bool
+	}{
+		{400, false},
+		{600, false},
+		{1, true},
+	}
+	for i, s := range steps {
+		err := l.Wait(canceled, rate, s.n)
+		if (err != nil) != s.wait {
+			t.Fatalf("step %d: err = %v, want wait %t", i, err, s.wait)
+		}
+	}
+
+	// 空闲后补充的令牌不超过 1 秒的流量
+	l.last = time.Now().Add(-time.Hour)
+	if err := l.Wait(canceled, rate, rate); err != nil {
+		t.Errorf("after idle: %v", err)
+	}
+	if l.tokens > 1 {
+		t.Errorf("tokens = %f, want at most 1", l.tokens)
+	}
+	if err := l.Wait(canceled, rate, 10); err == nil {
+		t.Error("burst larger than rate allowed")
+	}
+
+	// 令牌不足时按欠缺量等待
+	var l2 RateLimiter
+	const rate2 = 100000
+	l2.Wait(context.Background(), rate2, rate2)
+	start := time.Now()
+	if err := l2.Wait(context.Background(), rate2, rate2/20); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Errorf("waited %v, want about 50ms", elapsed)
+	}
+}
+
+func TestBandwidthManagerIdleClients(t *testing.T) {
+	mgr := NewBandwidthManager()
+	cl := mgr.acquire("10.0.0.1")
+	mgr.release("10.0.0.1")
+	// 空闲的令牌桶保留，下一个请求继续使用
+	if got := mgr.acquire("10.0.0.1"); got != cl || cl.refs != 1 {
+		t.Fatalf("limiter not reused: %p %p refs %d", got, cl, cl.refs)
+	}
+	mgr.release("10.0.0.1")
+
+	other := mgr.acquire("10.0.0.2")
+	// 超过空闲时长后移除，进行中的客户端保留
+	cl.lastUsed = time.Now().Add(-2 * limiterIdleTTL)
+	mgr.swept = time.Time{}
+	mgr.acquire("10.0.0.3")
+	if _, ok := mgr.clients["10.0.0.1"]; ok {
+		t.Error("idle limiter not evicted")
+	}
+	if mgr.clients["10.0.0.2"] != other {
+		t.Error("active limiter evicted")
+	}
+}
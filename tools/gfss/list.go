@@ -0,0 +1,205 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"toolkit/utils"
+)
+
+const defaultListLimit = 100
+const maxListLimit = 1000
+
+type FileEntry struct {
+	Name       string `json:"name"`
+	IsDir      bool   `json:"isDir"`
+	Size       int64  `json:"size"`
+	ModTime    int64  `json:"modTime"`    // 毫秒时间戳
+	CreateTime int64  `json:"createTime"` // 毫秒时间戳
+	MIME       string `json:"mime,omitempty"`
+}
+
+type ListV2Rsp struct {
+	Path       string      `json:"path"`
+	Parents    []Crumb     `json:"parents"`
+	Entries    []FileEntry `json:"entries"`
+	Total      int         `json:"total"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// 分页游标记录上一页最后一项的排序键，翻页期间增删文件也不会重复或遗漏
+type listCursor struct {
+	Name  string `json:"n"`
+	IsDir bool   `json:"d"`
+	Size  int64  `json:"s"`
+	Mod   int64  `json:"m"`
+	Ctime int64  `json:"c"`
+}
+
+// 文件列表 v2，参数：
+// path 文件夹，sort 排序字段 name/size/time/ctime（默认 ctime），order asc/desc（默认 desc），
+// q 文件名包含的文字，ext 逗号分隔的扩展名，limit 每页数量，cursor 上一页返回的 nextCursor
+// 文件夹总是排在文件前面
+func listV2(c *utils.Ctx) {
+	query := c.R.URL.Query()
+	dir, rel, err := resolvePath(query.Get("path"))
+	if err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, query.Get("path"))
+		return
+	}
+
+	limit := defaultListLimit
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			writeErrorRsp(c, http.StatusBadRequest, "参数错误", err, "limit", v)
+			return
+		}
+		limit = min(limit, maxListLimit)
+	}
+
+	sortBy := query.Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = "ctime"
+	case "name", "size", "time", "ctime":
+	default:
+		writeErrorRsp(c, http.StatusBadRequest, "参数错误", nil, "sort", sortBy)
+		return
+	}
+	desc := query.Get("order") != "asc"
+
+	var cursor *listCursor
+	if v := query.Get("cursor"); v != "" {
+		cursor = &listCursor{}
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			err = json.Unmarshal(b, cursor)
+		}
+		if err != nil {
+			writeErrorRsp(c, http.StatusBadRequest, "参数错误", err, "cursor", v)
+			return
+		}
+	}
+
+	list, err := readDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeErrorRsp(c, http.StatusNotFound, "文件夹不存在", err, rel)
+		} else {
+			writeErrorRsp(c, http.StatusInternalServerError, "获取文件失败", err, rel)
+		}
+		return
+	}
+
+	keyword := strings.ToLower(query.Get("q"))
+	var exts []string
+	for _, ext := range strings.Split(query.Get("ext"), ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" {
+			exts = append(exts, "."+strings.TrimPrefix(ext, "."))
+		}
+	}
+
+	keys := make([]listCursor, 0, len(list))
+	for _, it := range list {
+		lower := strings.ToLower(it.name)
+		if keyword != "" && !strings.Contains(lower, keyword) {
+			continue
+		}
+		if len(exts) > 0 && (it.isDir || !hasAnySuffix(lower, exts)) {
+			continue
+		}
+		keys = append(keys, listCursor{
+			Name:  it.name,
+			IsDir: it.isDir,
+			Size:  it.size,
+			Mod:   it.modTime.UnixMilli(),
+			Ctime: it.createAt.UnixMilli(),
+		})
+	}
+
+	less := func(a, b *listCursor) bool {
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		var r int
+		switch sortBy {
+		case "size":
+			r = cmp.Compare(a.Size, b.Size)
+		case "time":
+			r = cmp.Compare(a.Mod, b.Mod)
+		case "ctime":
+			r = cmp.Compare(a.Ctime, b.Ctime)
+		}
+		if r == 0 {
+			r = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+		if r == 0 {
+			r = strings.Compare(a.Name, b.Name)
+		}
+		if desc {
+			return r > 0
+		}
+		return r < 0
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return less(&keys[i], &keys[j])
+	})
+
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(keys), func(i int) bool {
+			return less(cursor, &keys[i])
+		})
+	}
+	end := min(start+limit, len(keys))
+
+	rsp := ListV2Rsp{
+		Path:    rel,
+		Parents: crumbs(rel),
+		Entries: make([]FileEntry, 0, end-start),
+		Total:   len(keys),
+	}
+	for i := start; i < end; i++ {
+		k := keys[i]
+		entry := FileEntry{
+			Name:       k.Name,
+			IsDir:      k.IsDir,
+			Size:       k.Size,
+			ModTime:    k.Mod,
+			CreateTime: k.Ctime,
+		}
+		if !k.IsDir {
+			entry.MIME = mime.TypeByExtension(filepath.Ext(k.Name))
+			if entry.MIME == "" {
+				entry.MIME = "application/octet-stream"
+			}
+		}
+		rsp.Entries = append(rsp.Entries, entry)
+	}
+	if end < len(keys) {
+		b, _ := json.Marshal(keys[end-1])
+		rsp.NextCursor = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.W.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(c.W).Encode(rsp)
+}
+
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,14 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	_ "embed"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"mime"
 	"net/http"
 	"net/url"
@@ -17,7 +15,6 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -27,7 +24,6 @@ import (
 	"github.com/amalfra/etag/v3"
 	"github.com/energye/systray"
 	"github.com/hymkor/trash-go"
-	"golang.org/x/sys/windows"
 )
 
 //go:embed index.html
@@ -38,9 +34,10 @@ var indexETag string
 var iconData []byte
 var iconETag string
 
-const maxTextSize = 2 * 1024 * 1024
-const maxFileSize = 3 * 1024 * 1024 * 1024
+const defaultMaxTextSize = 2 * 1024 * 1024
+const defaultMaxFileSize = 3 * 1024 * 1024 * 1024
 const defaultWorkDir = "upload"
+const defaultPort = 9527
 const appGuiMutex = `Global\FileShareServerGuiMutex_92746185032975`
 
 var serverName = "文件共享"
@@ -49,79 +46,187 @@ var execPath string
 var workDir string
 var showDir string
 var logPath = "false"
-var useTrash bool
 var port int64
-
-var textBuf bytes.Buffer
-var reqMux sync.RWMutex
+var scheme = "http"
 
 var tmpSuffix = ".part"
 var tfTracker *TmpFileTracker
 var dlTracker *DownloadTracker
+var shareMgr *ShareManager
+var bwMgr *BandwidthManager
+var hashCache *HashCache
+var dedupIdx *DedupIndex
+var padMgr *PadManager
+var dirWatcher *DirWatcher
+var storageMgr *StorageManager
+
+var auditLog *AuditLog
+
+var metricsMgr *Metrics
 
 var sseMgr *utils.SSEManager
 var log = utils.Ctx{}
 
 func main() {
-	ok, mutex := utils.CheckSingleInstance(appGuiMutex)
+	var tokenRole string
+	flag.StringVar(&confFile, "c", "", "配置文件，默认为程序目录下的 gfss.json")
+	flag.StringVar(&cliConf.WorkDir, "d", "", "工作目录")
+	flag.Int64Var(&cliConf.Port, "p", defaultPort, "端口号")
+	flag.BoolVar(&cliConf.Log, "l", false, "启用日志")
+	flag.BoolVar(&cliConf.Trash, "t", false, "启用回收站")
+	flag.StringVar(&cliConf.Password, "pwd", "", "访问密码")
+	flag.StringVar(&cliConf.Dedup, "dedup", "", "重复上传处理方式(skip/link)")
+	flag.StringVar(&cliConf.Mode, "mode", "", "服务模式(dropbox/readonly)")
+	flag.StringVar(&usersFile, "users", "", "用户配置文件")
+	flag.StringVar(&tokenRole, "token", "", "生成访问令牌的角色(read/upload/full)")
+	flag.BoolVar(&cliConf.TLS, "tls", false, "启用HTTPS")
+	flag.StringVar(&cliConf.Cert, "cert", "", "证书文件，不指定时自动生成自签名证书")
+	flag.StringVar(&cliConf.Key, "key", "", "私钥文件")
+	flag.Int64Var(&cliConf.Redirect, "redirect", 0, "重定向到HTTPS的HTTP端口")
+	flag.BoolVar(&cliConf.MDNS, "mdns", true, "通过 mDNS 发布服务")
+	browse := flag.Bool("browse", false, "查找局域网中的其他实例后退出")
+	flag.Parse()
+
+	hostName, _ = os.Hostname()
+	if *browse {
+		printPeers()
+		return
+	}
+
+	ok, release := utils.CheckSingleInstance(appGuiMutex)
 	if !ok {
 		if !utils.IsGuiMode {
 			fmt.Println("已存在运行实例，请勿再次启动！")
 		}
 		os.Exit(1)
 	}
-	defer windows.CloseHandle(mutex)
-
-	var useLogFile bool
-	flag.StringVar(&workDir, "d", "", "工作目录")
-	flag.Int64Var(&port, "p", 9527, "端口号")
-	flag.BoolVar(&useLogFile, "l", false, "启用日志")
-	flag.BoolVar(&useTrash, "t", false, "启用回收站")
-	flag.Parse()
+	defer release()
 
-	hostName, _ = os.Hostname()
 	execPath, _ = os.Executable()
 
-	if useLogFile || utils.IsGuiMode {
-		logPath = filepath.Join(filepath.Dir(execPath), "gfss.log")
+	token, err := initAuth(sessionKeyFile(), tokenRole)
+	if err != nil {
+		log.Errorf("认证配置错误: %v", err)
+		os.Exit(1)
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		log.Errorf("配置文件错误: %v", err)
+		os.Exit(1)
+	}
+	applyConfig(conf)
+
+	defaultLog := filepath.Join(filepath.Dir(execPath), "gfss.log")
+	certFile, keyFile := selfSignedFiles()
+	addSidecarFiles(execPath, configPath(), usersFile, defaultLog, certFile, keyFile, conf.Cert, conf.Key,
+		padsFile(), dedupFile(), auditFile(), uploadsFile(), sessionKeyFile(), sharesFile())
+
+	if conf.Log || utils.IsGuiMode {
+		logPath = defaultLog
 		utils.LogImpl.SetOut(logPath)
 	}
 	defer utils.LogImpl.Clean()
 
 	sseMgr = utils.NewSSEManager()
 	dlTracker = NewDownloadTracker()
-	tfTracker = NewTmpFileTracker()
+	tfTracker = NewTmpFileTracker(uploadsFile())
+	shareMgr = NewShareManager(sharesFile())
+	bwMgr = NewBandwidthManager()
+	hashCache = NewHashCache()
+	dedupIdx = NewDedupIndex(dedupFile())
+	padMgr = NewPadManager(padsFile())
+	dirWatcher = NewDirWatcher()
+	storageMgr = NewStorageManager()
+	auditLog = NewAuditLog(auditFile())
+	metricsMgr = NewMetrics()
+	defer auditLog.Close()
 	defer tfTracker.Clean()
+	defer dedupIdx.Close()
+	defer padMgr.Close()
+	defer shareMgr.Close()
 
-	setWorkDir()
-	port = utils.GetFreePort(port)
+	setWorkDir(conf.WorkDir)
+	tfTracker.Restore()
+	port = utils.GetFreePort(conf.Port)
 	addr := fmt.Sprintf(":%d", port)
 	host, ipMsg := utils.GetIP()
 
 	indexETag = etag.Generate(string(indexHTMl), true)
 	iconETag = etag.Generate(string(iconData), true)
 
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     &Engine{},
+		IdleTimeout: 10 * time.Second,
+	}
+	server.RegisterOnShutdown(sseMgr.Close)
+
+	var fingerprint string
+	if conf.TLS {
+		server.TLSConfig, fingerprint, err = loadTLSConfig(conf.Cert, conf.Key, host)
+		if err != nil {
+			log.Errorf("证书加载失败: %v", err)
+			os.Exit(1)
+		}
+		scheme = "https"
+	}
+
+	siteURL = fmt.Sprintf("%s://%s:%d", scheme, host, port)
 	log.Info("====================================")
 	log.Infof("网站名称：%s", serverName)
-	log.Infof("网站地址：http://%s:%d %s", host, port, ipMsg)
+	log.Infof("网站地址：%s %s", siteURL, ipMsg)
 	log.Infof("设备名称：%s", hostName)
 	log.Infof("工作目录：%s", workDir)
 	log.Infof("启用日志：%s", logPath)
-	log.Infof("启用回收站：%t", useTrash)
+	log.Infof("启用回收站：%t", conf.Trash)
+	log.Infof("启用认证：%t", authEnabled())
+	if conf.Mode != modeNormal {
+		log.Infof("服务模式：%s", conf.Mode)
+	}
+	if token != "" {
+		log.Infof("令牌链接：%s://%s:%d/?token=%s (%s)", scheme, host, port, token, tokenRole)
+	}
+	if conf.TLS {
+		log.Infof("证书指纹：SHA256 %s", fingerprint)
+	}
 	log.Info("====================================")
-
-	server := &http.Server{
-		Addr:        addr,
-		Handler:     &Engine{},
-		IdleTimeout: 10 * time.Second,
+	if !utils.IsGuiMode {
+		printQR()
 	}
+
 	go func() {
-		if err := server.ListenAndServe(); err != nil &&
-			!errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if conf.TLS {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Errorf("服务启动失败: %v\n", err)
 		}
 	}()
 
+	go dirWatcher.Run()
+	go storageMgr.Run()
+	go dedupIdx.Run()
+	go watchConfig()
+
+	stopMDNS := func() {}
+	if conf.MDNS {
+		stopMDNS = advertise()
+	}
+
+	var redirectServer *http.Server
+	if conf.TLS && conf.Redirect > 0 {
+		redirectServer = newRedirectServer(conf.Redirect)
+		go func() {
+			if err := redirectServer.ListenAndServe(); err != nil &&
+				!errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("重定向服务启动失败: %v\n", err)
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
@@ -134,6 +239,10 @@ func main() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	stopMDNS()
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
 	server.Shutdown(ctx)
 }
 
@@ -141,7 +250,7 @@ func showTray(q chan os.Signal) {
 	// 防止右键不显示菜单，需要禁止 Go 调度器切换系统线程
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
-	localLink := fmt.Sprintf("http://127.0.0.1:%d", port)
+	localLink := fmt.Sprintf("%s://127.0.0.1:%d", scheme, port)
 	systray.Run(func() {
 		systray.SetIcon(iconData)
 		systray.SetTitle(serverName)
@@ -159,18 +268,18 @@ func showTray(q chan os.Signal) {
 			utils.ExplorerOpen(logPath)
 		})
 		systray.AddMenuItem("查看连接", "").Click(func() {
-			sseMgr.BroadcastLocal("ips", sseMgr.IPs())
+			sseMgr.BroadcastGui("ips", sseMgr.IPs())
 		})
 		systray.AddSeparator()
-		trashMenu := systray.AddMenuItemCheckbox("启用回收站", "", useTrash)
+		trashMenu := systray.AddMenuItemCheckbox("启用回收站", "", curConf().Trash)
 		trashMenu.Click(func() {
-			if trashMenu.Checked() {
-				trashMenu.Uncheck()
-				useTrash = false
-			} else {
+			useTrash := !trashMenu.Checked()
+			if useTrash {
 				trashMenu.Check()
-				useTrash = true
+			} else {
+				trashMenu.Uncheck()
 			}
+			updateConfig(func(conf *Config) { conf.Trash = useTrash })
 			sseMgr.Broadcast("refresh", nil)
 		})
 		systray.AddSeparator()
@@ -200,53 +309,129 @@ type Engine struct{}
 func (*Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var c = ctxPool.Get().(*utils.Ctx)
 	defer ctxPool.Put(c)
-	c.W, c.R = w, r
+	mw := &metricsWriter{ResponseWriter: w}
+	c.W, c.R = mw, r
 	if idx := strings.LastIndex(r.RemoteAddr, ":"); idx != -1 {
 		c.ID = r.RemoteAddr[:idx]
 	} else {
 		c.ID = r.RemoteAddr
 	}
+	handler, perm := route(r)
+	defer func() {
+		status := mw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metricsMgr.Request(routeName(handler), r.Method, status)
+	}()
+	if !ipAllowed(c.ID) {
+		writeErrorRsp(c, http.StatusForbidden, "禁止访问", nil, r.URL.Path)
+		return
+	}
+	if !modeAllowed(c, perm) || !authorize(c, perm) {
+		return
+	}
+	handler(c)
+}
+
+// 根据请求方法及路径选择处理函数及所需权限
+func route(r *http.Request) (func(*utils.Ctx), int) {
+	if r.URL.Path == davPrefix || strings.HasPrefix(r.URL.Path, davPrefix+"/") {
+		return dav, davPerm(r.Method)
+	}
 	switch r.Method {
 	case http.MethodGet:
 		if r.URL.Path == "/sse" {
-			sseMgr.SSE(c)
-			return
+			return sseMgr.SSE, permRead
+		} else if r.URL.Path == "/clients" {
+			return clients, permLogin
+		} else if r.URL.Path == "/audit" {
+			return auditQuery, permLogin
+		} else if r.URL.Path == "/metrics" {
+			return metrics, permLogin
 		} else if r.URL.Path == "/info" {
-			info(c)
-			return
+			return info, permLogin
 		} else if r.URL.Path == "/text" {
-			text(c)
-			return
+			return text, permRead
+		} else if r.URL.Path == "/text/history" {
+			return textHistory, permRead
+		} else if r.URL.Path == "/text/pads" {
+			return listPads, permRead
+		} else if r.URL.Path == "/peers" {
+			return peers, permRead
 		} else if r.URL.Path == "/list" {
-			list(c)
-			return
+			return list, permRead
+		} else if r.URL.Path == "/v2/list" {
+			return listV2, permRead
 		} else if r.URL.Path == "/favicon.ico" {
-			favicon(c)
-			return
+			return favicon, permPublic
+		} else if r.URL.Path == "/qr.png" {
+			return qrCode, permLogin
+		} else if r.URL.Path == "/archive" {
+			return archive, permRead
+		} else if r.URL.Path == "/share" {
+			return listShares, permShare
 		} else if strings.HasPrefix(r.URL.Path, "/dl/") {
-			download(c)
-			return
+			return download, permRead
+		} else if strings.HasPrefix(r.URL.Path, "/view/") {
+			return view, permRead
+		} else if strings.HasPrefix(r.URL.Path, "/thumb/") {
+			return thumb, permRead
+		} else if strings.HasPrefix(r.URL.Path, "/hash/") {
+			return fileHash, permRead
+		} else if strings.HasPrefix(r.URL.Path, "/s/") {
+			return shareDownload, permPublic
+		}
+	case http.MethodHead:
+		if strings.HasPrefix(r.URL.Path, "/upload/") {
+			return headUpload, permUpload
+		} else if strings.HasPrefix(r.URL.Path, "/dl/") {
+			return download, permRead
+		} else if strings.HasPrefix(r.URL.Path, "/view/") {
+			return view, permRead
+		} else if strings.HasPrefix(r.URL.Path, "/s/") {
+			return shareDownload, permPublic
+		}
+	case http.MethodPatch:
+		if strings.HasPrefix(r.URL.Path, "/upload/") {
+			return patchUpload, permUpload
 		}
 	case http.MethodPost:
 		switch r.URL.Path {
 		case "/text":
-			modText(c)
-			return
+			return modText, permUpload
+		case "/text/delete":
+			return delPad, permUpload | permDelete
 		case "/upload":
-			upload(c)
-			return
+			return upload, permUpload
+		case "/upload/new":
+			return createUpload, permUpload
+		case "/mkdir":
+			return mkdir, permUpload
+		case "/rename", "/move":
+			return moveFile, permUpload | permDelete
+		case "/copy":
+			return copyFile, permRead | permUpload
+		case "/archive":
+			return archive, permRead
+		case "/share":
+			return createShare, permShare
+		case "/share/revoke":
+			return revokeShare, permShare
+		case "/login":
+			return login, permPublic
+		case "/logout":
+			return logout, permPublic
 		}
 	case http.MethodDelete:
-		delFile(c)
-		return
+		return delFile, permDelete
 	}
-	index(c)
+	return index, permLogin
 }
 
-func setWorkDir() {
-	var dir string
-	if workDir != "" {
-		dir, _ = utils.IsDirExist(utils.NormalizePath(workDir))
+func setWorkDir(dir string) {
+	if dir != "" {
+		dir, _ = utils.IsDirExist(utils.NormalizePath(dir))
 	}
 
 	arg0 := flag.Arg(0)
@@ -276,6 +461,10 @@ func updateWorkDir(dir string) {
 	}
 	workDir = dir
 	showDir = utils.ShrinkHomePath(workDir)
+	if dirWatcher != nil {
+		dirWatcher.Kick()
+		dedupIdx.Kick()
+	}
 }
 
 type InfoRsp struct {
@@ -283,6 +472,8 @@ type InfoRsp struct {
 	WorkDir   string `json:"workDir"`
 	DelDesc   string `json:"delDesc"`
 	IsGuiMode bool   `json:"isGuiMode"`
+	Role      string `json:"role"`
+	Mode      string `json:"mode"`
 }
 
 func info(c *utils.Ctx) {
@@ -291,75 +482,81 @@ func info(c *utils.Ctx) {
 		WorkDir:   showDir,
 		DelDesc:   "删除",
 		IsGuiMode: utils.IsGuiMode,
+		Role:      currentRole(c),
+		Mode:      clientMode(c),
 	}
-	if useTrash {
+	if curConf().Trash {
 		rsp.DelDesc = "移除"
 	}
 	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(c.W).Encode(rsp)
 }
 
-func modText(c *utils.Ctx) {
-	tempBytes, err := io.ReadAll(http.MaxBytesReader(c.W, c.R.Body, maxTextSize))
-	if err != nil {
-		var maxBytesErr *http.MaxBytesError
-		if errors.As(err, &maxBytesErr) {
-			writeErrorRsp(c, http.StatusRequestEntityTooLarge,
-				fmt.Sprintf("文本超出%s限制", utils.FormatBytesIEC(maxTextSize)), err)
-			return
-		}
-		writeErrorRsp(c, http.StatusBadRequest, "参数错误", err)
+// 当前 SSE 连接列表，仅限本机访问
+func clients(c *utils.Ctx) {
+	if !utils.IsLocalIP(c.ID) {
+		writeErrorRsp(c, http.StatusForbidden, "仅限本机访问", nil, c.R.URL.Path)
 		return
 	}
-
-	reqMux.Lock()
-	defer reqMux.Unlock()
-	textBuf.Reset()
-	textBuf.Write(tempBytes)
-	c.Info(utils.FormatBytesIEC(int64(textBuf.Len())))
-	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
-}
-
-func text(c *utils.Ctx) {
-	reqMux.RLock()
-	defer reqMux.RUnlock()
 	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
-	c.W.Write(textBuf.Bytes())
+	c.W.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(c.W).Encode(sseMgr.IPs())
 }
 
 func delFile(c *utils.Ctx) {
 	fileName, err := url.PathUnescape(strings.TrimPrefix(c.R.URL.Path, "/"))
-	if err != nil || strings.Contains(fileName, "/") {
+	if err != nil {
 		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, fileName)
 		return
 	}
 
-	fp := filepath.Join(workDir, fileName)
-	if fp == execPath {
+	fp, rel, err := resolvePath(fileName)
+	if err != nil || isProtectedPath(fp) {
 		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, fileName)
 		return
 	}
+	fileName = rel
 
+	var now = time.Now()
+	useTrash := curConf().Trash
+	op := "delete"
+	if useTrash {
+		op = "trash"
+	}
 	if dlTracker.IsDownloading(fileName) {
 		writeErrorRsp(c, http.StatusForbidden, "文件正在被下载", err, fileName)
+		audit(c, op, fileName, 0, 0, http.StatusForbidden, "")
 		return
 	}
+	// 文件夹不统计大小
+	var size int64
+	var sum string
+	if info, err := os.Lstat(fp); err == nil && info.Mode().IsRegular() {
+		size = info.Size()
+		sum, _ = hashCache.Get(fp, info)
+	}
 
 	if useTrash {
 		err = trash.Throw(fp)
 		if err != nil {
 			writeErrorRsp(c, http.StatusInternalServerError, "放入回收站失败", err, fileName)
+			audit(c, op, fileName, size, time.Since(now), http.StatusInternalServerError, sum)
 			return
 		}
 		c.Info("t", fileName)
+		dedupIdx.Remove(fileName)
 	} else {
-		err = os.Remove(fp)
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// RemoveAll 对不存在的路径返回 nil
+		err = os.RemoveAll(fp)
+		if err != nil {
 			writeErrorRsp(c, http.StatusInternalServerError, "删除文件失败", err, fileName)
+			audit(c, op, fileName, size, time.Since(now), http.StatusInternalServerError, sum)
 			return
 		}
 		c.Info("d", fileName)
+		dedupIdx.Remove(fileName)
 	}
+	audit(c, op, fileName, size, time.Since(now), http.StatusOK, sum)
 }
 
 func index(c *utils.Ctx) {
@@ -379,6 +576,10 @@ func index(c *utils.Ctx) {
 }
 
 func list(c *utils.Ctx) {
+	if c.R.URL.Query().Has("path") {
+		listPath(c)
+		return
+	}
 	list, err := getFiles()
 	if err != nil {
 		writeErrorRsp(c, http.StatusInternalServerError, "获取文件失败", err)
@@ -398,233 +599,204 @@ func favicon(c *utils.Ctx) {
 	c.W.Write(iconData)
 }
 
-var uploadBufPool = sync.Pool{
-	New: func() any {
-		return make([]byte, 1*1024*1024)
-	},
-}
-
-func upload(c *utils.Ctx) {
-	var now = time.Now()
-	// 使用流式 multipart 解析，避免将整个文件缓存在内存
-	mr, err := c.R.MultipartReader()
+func download(c *utils.Ctx) {
+	fileName, err := url.PathUnescape(strings.TrimPrefix(c.R.URL.Path, "/dl/"))
 	if err != nil {
-		writeErrorRsp(c, http.StatusBadRequest, "无效表单", err)
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", nil, fileName)
 		return
 	}
+	serveFile(c, fileName, false)
+}
 
-	var finalName string
-	var total int64
-
-	for {
-		part, err := mr.NextPart()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			writeErrorRsp(c, http.StatusInternalServerError, "读取文件错误", err)
-			return
-		}
-
-		// 只处理名为 "file" 的文件字段
-		if part.FormName() != "file" {
-			part.Close()
-			continue
-		}
-
-		fname := filepath.Base(part.FileName())
-		if fname == "" {
-			part.Close()
-			writeErrorRsp(c, http.StatusBadRequest, "没有文件名", nil)
-			return
-		}
-
-		out, err := os.CreateTemp(workDir, "*"+tmpSuffix)
-		if err != nil {
-			part.Close()
-			writeErrorRsp(c, http.StatusInternalServerError, "创建临时文件失败", err, fname)
-			return
-		}
-		fPathTmp := out.Name()
-		fnameTmp := filepath.Base(fPathTmp)
-		tfTracker.Push(fnameTmp, out)
-
-		defer os.Remove(fPathTmp)
-
-		buf := uploadBufPool.Get().([]byte)
-		n, err := io.CopyBuffer(out, io.LimitReader(part, maxFileSize+1), buf)
-		uploadBufPool.Put(buf)
-
-		out.Close()
-		part.Close()
-		tfTracker.Pop(fnameTmp)
-
-		if n > maxFileSize {
-			writeErrorRsp(c, http.StatusRequestEntityTooLarge,
-				fmt.Sprintf("文件超出%s限制", utils.FormatBytesIEC(maxFileSize)), nil, fname)
-			return
-		}
-
-		if err != nil {
-			writeErrorRsp(c, http.StatusInternalServerError, "保存文件失败", err, fnameTmp)
-			return
-		}
-
-		baseName := strings.TrimSuffix(fname, filepath.Ext(fname))
-		ext := filepath.Ext(fname)
-		counter := 0
-
-		var finalPath string
-		for {
-			if counter == 0 {
-				finalPath = filepath.Join(workDir, fname)
-			} else {
-				finalPath = filepath.Join(workDir, fmt.Sprintf("%s(%d)%s", baseName, counter, ext))
-			}
-
-			f, err := os.OpenFile(finalPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
-			if err == nil {
-				f.Close()
-				break
-			}
-
-			// 说明是没有写入权限或其他严重错误，直接中断
-			if !os.IsExist(err) {
-				writeErrorRsp(c, http.StatusInternalServerError, "检查目标文件冲突失败", err, fname)
-				return
-			}
-
-			counter++
-		}
-
-		if err = os.Rename(fPathTmp, finalPath); err != nil {
-			os.Remove(finalPath)
-			writeErrorRsp(c, http.StatusInternalServerError, "重命名文件失败", err, fnameTmp)
-			return
-		}
-
-		total += n
-		finalName = filepath.Base(finalPath)
-	}
-
-	c.W.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	if total == 0 {
-		writeErrorRsp(c, http.StatusBadRequest, "没有检测到文件上传", nil)
+// 在浏览器中直接打开图片、PDF、文本及音视频，其他类型仍作为附件下载
+func view(c *utils.Ctx) {
+	fileName, err := url.PathUnescape(strings.TrimPrefix(c.R.URL.Path, "/view/"))
+	if err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", nil, fileName)
 		return
 	}
-
-	elapsed := time.Since(now)
-	speed := int64(0)
-	if elapsed > 0 {
-		speed = int64(float64(total) / elapsed.Seconds())
-	}
-	c.Infof(
-		"%s %s %v %s/s",
-		finalName,
-		utils.FormatBytesIEC(total),
-		elapsed.Round(time.Millisecond),
-		utils.FormatBytesIEC(speed),
-	)
+	serveFile(c, fileName, true)
 }
 
-func download(c *utils.Ctx) {
+// 发送工作目录下的文件，fileName 为以 / 分隔的相对路径，inline 为 true 时尽量在浏览器中直接显示
+// 返回是否开始发送文件内容，即 GET 请求的 200 或 206 响应
+func serveFile(c *utils.Ctx, fileName string, inline bool) bool {
 	var now = time.Now()
-	fileName, err := url.PathUnescape(strings.TrimPrefix(c.R.URL.Path, "/dl/"))
-	if err != nil || strings.Contains(fileName, "/") {
-		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", nil, fileName)
-		return
+	fp, rel, err := resolvePath(fileName)
+	if err != nil || rel == "" {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, fileName)
+		return false
 	}
+	fileName = rel
 
-	file, err := os.Open(filepath.Join(workDir, fileName))
+	file, err := os.Open(fp)
 	if err != nil {
 		if os.IsNotExist(err) {
 			writeErrorRsp(c, http.StatusNotFound, "文件不存在", err, fileName)
 		} else {
 			writeErrorRsp(c, http.StatusInternalServerError, "无法打开文件", err, fileName)
 		}
-		return
+		return false
 	}
 	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
 		writeErrorRsp(c, http.StatusInternalServerError, "读取文件信息失败", err, fileName)
-		return
+		return false
 	}
 
 	if fileInfo.IsDir() || utils.IsIgnoreFile(fileInfo) {
 		writeErrorRsp(c, http.StatusBadRequest, "非文件路径", nil, fileName)
-		return
+		return false
 	}
 
 	dlTracker.Start(fileName)
 	defer dlTracker.End(fileName)
 
-	fileHeader := make([]byte, 512)
-	_, err = file.Read(fileHeader)
-	if err != nil && err != io.EOF {
-		writeErrorRsp(c, http.StatusInternalServerError, "读取文件失败", err, fileName)
-		return
-	}
-
-	ctype := mime.TypeByExtension(filepath.Ext(fileName))
+	baseName := filepath.Base(fp)
+	ctype := mime.TypeByExtension(filepath.Ext(baseName))
 	if ctype == "" {
-		// ctype = http.DetectContentType(fileHeader)
 		ctype = "application/octet-stream"
 	}
+	disposition := attachmentDisposition(baseName)
+	if inline {
+		if t, ok := inlineType(baseName, ctype); ok {
+			ctype = t
+			disposition = "inline" + strings.TrimPrefix(disposition, "attachment")
+			// SVG 中可以包含脚本，在沙箱中打开
+			if strings.HasPrefix(ctype, "image/svg") {
+				c.W.Header().Set("Content-Security-Policy", "sandbox")
+			}
+		}
+	}
 	c.W.Header().Set("Content-Type", ctype)
 	c.W.Header().Set("X-Content-Type-Options", "nosniff")
-	c.W.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", fileName, url.PathEscape(fileName)))
-	c.W.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
+	c.W.Header().Set("Content-Disposition", disposition)
+	c.W.Header().Set("Accept-Ranges", "bytes")
+	c.W.Header().Set("ETag", fileETag(fileInfo))
+
+	// ServeContent 负责 Range/If-Range/If-Modified-Since/If-None-Match 及 206/304/416 响应
+	w, done := bwMgr.Writer(c)
+	defer done()
+	sw := &statWriter{ResponseWriter: metricsMgr.Writer(w), status: http.StatusOK}
+	http.ServeContent(sw, c.R, baseName, fileInfo.ModTime(), file)
+
+	if c.R.Method == http.MethodHead {
+		c.Info(sw.status, c.R.Method, fileName, c.R.Header.Get("Range"))
+		return false
+	}
+	sum, _ := hashCache.Get(fp, fileInfo)
+	audit(c, "download", fileName, sw.written, time.Since(now), sw.status, sum)
+	if sw.status >= http.StatusBadRequest {
+		c.Info(sw.status, c.R.Method, fileName, c.R.Header.Get("Range"))
+		return false
+	}
+	if sw.status != http.StatusOK && sw.status != http.StatusPartialContent {
+		return false
+	}
+	if rng := c.R.Header.Get("Range"); rng != "" && sw.status == http.StatusPartialContent {
+		fileName = fmt.Sprintf("%s [%s]", fileName, rng)
+	}
+	logTransfer(c, fileName, sw.written, time.Since(now))
+	return true
+}
 
-	_, err = file.Seek(0, 0)
-	if err != nil {
-		writeErrorRsp(c, http.StatusInternalServerError, "重置文件指针失败", err, fileName)
-		return
-	}
+func attachmentDisposition(fileName string) string {
+	return fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", fileName, url.PathEscape(fileName))
+}
 
-	total, err := io.Copy(c.W, file)
-	if err != nil {
-		c.Errorf("传输失败: %v", err)
-		return
-	}
+// 可在浏览器中直接显示的类型，文本统一按纯文本显示，避免执行其中的 HTML 和脚本
+func inlineType(fileName, ctype string) (string, bool) {
+	mediaType, _, _ := mime.ParseMediaType(ctype)
+	switch {
+	case mediaType == "application/pdf":
+		return ctype, true
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"):
+		return ctype, true
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		mediaType == "application/xml",
+		mediaType == "application/javascript",
+		textExts[strings.ToLower(filepath.Ext(fileName))]:
+		return "text/plain; charset=utf-8", true
+	}
+	return "", false
+}
 
-	elapsed := time.Since(now)
-	speed := int64(0)
-	if elapsed > 0 {
-		speed = int64(float64(total) / elapsed.Seconds())
-	}
-	c.Infof(
-		"%s %s %v %s/s",
-		fileName,
-		utils.FormatBytesIEC(total),
-		elapsed.Round(time.Millisecond),
-		utils.FormatBytesIEC(speed),
-	)
+// 系统未登记类型的常见文本文件
+var textExts = map[string]bool{
+	".txt": true, ".log": true, ".md": true, ".ini": true, ".conf": true,
+	".yaml": true, ".yml": true, ".toml": true, ".csv": true, ".sql": true,
+	".sh": true, ".bat": true, ".ps1": true, ".go": true, ".py": true,
+	".c": true, ".h": true, ".cpp": true, ".java": true, ".rs": true,
+	".ts": true, ".vue": true,
+}
+
+// 根据修改时间和大小生成强校验 ETag，保证 If-Range 可用
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+// 记录响应状态码及写出字节数
+type statWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *statWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
 }
 
 type fileInfo struct {
 	name     string
+	isDir    bool
+	size     int64
+	modTime  time.Time
 	createAt time.Time
 }
 
 func getFiles() (files []string, err error) {
 	files = make([]string, 0)
 
-	fs, err := os.ReadDir(workDir)
+	list, err := readDir(workDir)
 	if err != nil {
 		return files, err
 	}
 
-	var list []fileInfo
+	for _, it := range list {
+		if !it.isDir {
+			files = append(files, it.name)
+		}
+	}
+	return
+}
+
+// 读取文件夹下可见的文件及子文件夹，按创建时间倒序
+func readDir(dir string) (list []fileInfo, err error) {
+	fs, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, e := range fs {
-		if !e.Type().IsRegular() {
+		if !e.Type().IsRegular() && !e.IsDir() {
 			continue
 		}
 
 		name := e.Name()
 		if strings.HasSuffix(name, tmpSuffix) ||
-			filepath.Join(workDir, name) == execPath {
+			isSidecarFile(filepath.Join(dir, name)) {
 			continue
 		}
 
@@ -633,10 +805,15 @@ func getFiles() (files []string, err error) {
 			continue
 		}
 
-		fi := fileInfo{name: name, createAt: info.ModTime()}
-		stat, ok := info.Sys().(*syscall.Win32FileAttributeData)
-		if ok {
-			fi.createAt = time.Unix(0, stat.CreationTime.Nanoseconds())
+		fi := fileInfo{
+			name:     name,
+			isDir:    e.IsDir(),
+			size:     info.Size(),
+			modTime:  info.ModTime(),
+			createAt: utils.CreateTime(filepath.Join(dir, name), info),
+		}
+		if fi.isDir {
+			fi.size = 0
 		}
 		list = append(list, fi)
 	}
@@ -644,10 +821,6 @@ func getFiles() (files []string, err error) {
 	sort.Slice(list, func(i, j int) bool {
 		return list[i].createAt.After(list[j].createAt)
 	})
-
-	for _, it := range list {
-		files = append(files, it.name)
-	}
 	return
 }
 
@@ -670,38 +843,6 @@ func writeErrorRsp(c *utils.Ctx, status int, msg string, err error, remarks ...s
 	c.W.Write([]byte(msg))
 }
 
-type TmpFileTracker struct {
-	mux   sync.Mutex
-	files map[string]*os.File
-}
-
-func NewTmpFileTracker() *TmpFileTracker {
-	return &TmpFileTracker{
-		files: make(map[string]*os.File),
-	}
-}
-
-func (t *TmpFileTracker) Push(name string, f *os.File) {
-	t.mux.Lock()
-	defer t.mux.Unlock()
-	t.files[name] = f
-}
-
-func (t *TmpFileTracker) Pop(name string) {
-	t.mux.Lock()
-	defer t.mux.Unlock()
-	delete(t.files, name)
-}
-
-func (t *TmpFileTracker) Clean() {
-	for name, f := range t.files {
-		if f != nil {
-			f.Close()
-		}
-		os.Remove(filepath.Join(workDir, name))
-	}
-}
-
 type DownloadTracker struct {
 	mux   sync.RWMutex
 	files map[string]int
@@ -731,9 +872,32 @@ func (t *DownloadTracker) End(name string) {
 	t.files[name]--
 }
 
+// 正在进行的下载数量，同一文件的多个下载分别计数
+func (t *DownloadTracker) Count() int {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	var n int
+	for _, v := range t.files {
+		n += v
+	}
+	return n
+}
+
+// 判断文件或文件夹内是否有文件正在被下载
 func (t *DownloadTracker) IsDownloading(name string) bool {
 	t.mux.RLock()
 	defer t.mux.RUnlock()
 
-	return t.files[name] > 0
+	if t.files[name] > 0 {
+		return true
+	}
+	if name == "" {
+		return len(t.files) > 0
+	}
+	for k := range t.files {
+		if strings.HasPrefix(k, name+"/") {
+			return true
+		}
+	}
+	return false
 }
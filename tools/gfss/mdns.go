@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"toolkit/utils"
+)
+
+const mdnsService = "_http._tcp"
+
+// 浏览时等待其他实例响应的时长
+const browseTimeout = 2 * time.Second
+
+type Peer struct {
+	Name     string   `json:"name"`
+	HostName string   `json:"hostName"`
+	URL      string   `json:"url"`
+	IPs      []string `json:"ips"`
+}
+
+func mdnsInstance() string {
+	return serverName + " on " + hostName
+}
+
+// 通过 mDNS 发布服务，返回停止发布的函数
+func advertise() func() {
+	// 使用独立的主机名，不与系统 mDNS 服务发布的 <主机名>.local 冲突
+	host, _, _ := strings.Cut(hostName, ".")
+	adv, err := utils.AdvertiseMDNS(utils.MDNSService{
+		Instance: mdnsInstance(),
+		Service:  mdnsService,
+		Host:     host + "-gfss",
+		Port:     int(port),
+		Text: []string{
+			"app=gfss",
+			"path=/",
+			"scheme=" + scheme,
+			"hostName=" + hostName,
+			"serverName=" + serverName,
+		},
+	})
+	if err != nil {
+		log.Error("mDNS 发布失败", err)
+		return func() {}
+	}
+	return adv.Close
+}
+
+// 查找局域网中的其他 gfss 实例
+func browsePeers(ctx context.Context) ([]Peer, error) {
+	entries, err := utils.BrowseMDNS(ctx, mdnsService)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Peer, 0, len(entries))
+	for _, e := range entries {
+		if e.Text["app"] != "gfss" {
+			continue
+		}
+		// 排除本机实例
+		if e.Instance == mdnsInstance() && e.Port == int(port) {
+			continue
+		}
+		peer := Peer{Name: e.Text["serverName"], HostName: e.Text["hostName"], IPs: e.IPs}
+		host := e.Host
+		if len(e.IPs) > 0 {
+			host = e.IPs[0]
+		}
+		s := e.Text["scheme"]
+		if s == "" {
+			s = "http"
+		}
+		peer.URL = s + "://" + net.JoinHostPort(host, strconv.Itoa(e.Port))
+		list = append(list, peer)
+	}
+	return list, nil
+}
+
+func peers(c *utils.Ctx) {
+	ctx, cancel := context.WithTimeout(c.R.Context(), browseTimeout)
+	defer cancel()
+	list, err := browsePeers(ctx)
+	if err != nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "查找失败", err)
+		return
+	}
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.W.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(c.W).Encode(list)
+}
+
+// -browse 参数：打印局域网中的 gfss 实例后退出
+func printPeers() {
+	ctx, cancel := context.WithTimeout(context.Background(), browseTimeout)
+	defer cancel()
+	list, err := browsePeers(ctx)
+	if err != nil {
+		fmt.Println("查找失败:", err)
+		return
+	}
+	if len(list) == 0 {
+		fmt.Println("未发现其他实例")
+		return
+	}
+	for _, p := range list {
+		fmt.Printf("%s(%s)\t%s\n", p.Name, p.HostName, p.URL)
+	}
+}
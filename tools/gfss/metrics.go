@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"toolkit/utils"
+)
+
+// 传输耗时直方图的分桶上限，单位秒
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 1800}
+
+type requestKey struct {
+	route  string
+	method string
+	status int
+}
+
+type histogram struct {
+	counts []uint64 // 各分桶的计数，不累加
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range durationBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Prometheus 文本格式的运行指标，不依赖客户端库
+type Metrics struct {
+	mux        sync.Mutex
+	requests   map[requestKey]uint64
+	durations  map[string]*histogram // upload、download
+	uploaded   atomic.Uint64
+	downloaded atomic.Uint64
+	start      time.Time
+}
+
+func NewMetrics() *Metrics {
+	t := &Metrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[string]*histogram),
+		start:     time.Now(),
+	}
+	for _, dir := range []string{"upload", "download"} {
+		t.durations[dir] = &histogram{counts: make([]uint64, len(durationBuckets))}
+	}
+	return t
+}
+
+// 记录一次请求，route 为处理函数名称，避免以路径作为标签导致数量无限增长
+func (t *Metrics) Request(route, method string, status int) {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, "PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK":
+	default:
+		method = "OTHER"
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.requests[requestKey{route, method, status}]++
+}
+
+// 记录上传或下载完成的耗时，字节数在传输过程中通过 Reader、Writer 计入
+func (t *Metrics) Transfer(op string, elapsed time.Duration, status int) {
+	var dir string
+	switch op {
+	case "upload":
+		dir = "upload"
+	case "download", "archive":
+		dir = "download"
+	default:
+		return
+	}
+	if status >= http.StatusBadRequest {
+		return
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.durations[dir].observe(elapsed.Seconds())
+}
+
+// 包装上传的文件数据，读取的同时计入上传字节数，中断及失败的上传同样计入已读取的部分
+func (t *Metrics) Reader(r io.Reader) io.Reader {
+	return &countReader{Reader: r, n: &t.uploaded}
+}
+
+// 包装下载的响应，写出的同时计入下载字节数
+func (t *Metrics) Writer(w http.ResponseWriter) http.ResponseWriter {
+	return &countWriter{ResponseWriter: w, n: &t.downloaded}
+}
+
+type countReader struct {
+	io.Reader
+	n *atomic.Uint64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n.Add(uint64(n))
+	return n, err
+}
+
+type countWriter struct {
+	http.ResponseWriter
+	n *atomic.Uint64
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n.Add(uint64(n))
+	return n, err
+}
+
+func (w *countWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (t *Metrics) WriteTo(w *bufio.Writer) {
+	t.mux.Lock()
+	keys := make([]requestKey, 0, len(t.requests))
+	for k := range t.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	fmt.Fprintln(w, "# HELP gfss_requests_total HTTP requests by route, method and status.")
+	fmt.Fprintln(w, "# TYPE gfss_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "gfss_requests_total{route=%q,method=%q,status=\"%d\"} %d\n",
+			k.route, k.method, k.status, t.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP gfss_transfer_bytes_total Bytes transferred by file uploads and downloads.")
+	fmt.Fprintln(w, "# TYPE gfss_transfer_bytes_total counter")
+	fmt.Fprintf(w, "gfss_transfer_bytes_total{direction=\"download\"} %d\n", t.downloaded.Load())
+	fmt.Fprintf(w, "gfss_transfer_bytes_total{direction=\"upload\"} %d\n", t.uploaded.Load())
+
+	fmt.Fprintln(w, "# HELP gfss_transfer_duration_seconds Duration of completed file uploads and downloads.")
+	fmt.Fprintln(w, "# TYPE gfss_transfer_duration_seconds histogram")
+	for _, dir := range []string{"download", "upload"} {
+		h := t.durations[dir]
+		var cum uint64
+		for i, le := range durationBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(w, "gfss_transfer_duration_seconds_bucket{direction=%q,le=%q} %d\n",
+				dir, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(w, "gfss_transfer_duration_seconds_bucket{direction=%q,le=\"+Inf\"} %d\n", dir, h.count)
+		fmt.Fprintf(w, "gfss_transfer_duration_seconds_sum{direction=%q} %s\n",
+			dir, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "gfss_transfer_duration_seconds_count{direction=%q} %d\n", dir, h.count)
+	}
+	start := t.start
+	t.mux.Unlock()
+
+	gauge := func(name, help string, v any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, v)
+	}
+	gauge("gfss_sse_clients", "Connected SSE clients.", sseMgr.Count())
+	gauge("gfss_downloads_in_flight", "Files currently being downloaded, archived or copied.", dlTracker.Count())
+	gauge("gfss_upload_temp_files", "Temporary files of unfinished uploads.", tfTracker.Count())
+	if free, err := utils.DiskFree(workDir); err == nil {
+		gauge("gfss_disk_free_bytes", "Free disk space available to the work directory.", free)
+	}
+	gauge("gfss_start_time_seconds", "Start time of the process since unix epoch.", start.Unix())
+}
+
+// 以处理函数名称作为路由标签，如 download、upload、SSE
+func routeName(handler func(*utils.Ctx)) string {
+	f := runtime.FuncForPC(reflect.ValueOf(handler).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	name := strings.TrimSuffix(f.Name(), "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// 记录响应状态码，SSE 需要 Flush
+type metricsWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *metricsWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *metricsWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *metricsWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *metricsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func metrics(c *utils.Ctx) {
+	c.W.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.W.Header().Set("Cache-Control", "no-store")
+	w := bufio.NewWriter(c.W)
+	metricsMgr.WriteTo(w)
+	w.Flush()
+}
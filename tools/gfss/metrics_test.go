@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestMetricsTransferBytes(t *testing.T) {
+	m := NewMetrics()
+
+	// 中途失败的读取也计入已读取的部分
+	r := m.Reader(io.MultiReader(strings.NewReader("hello"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("expected read error")
+	}
+	io.Copy(io.Discard, m.Reader(strings.NewReader("world!")))
+	if got := m.uploaded.Load(); got != 11 {
+		t.Errorf("uploaded = %d, want 11", got)
+	}
+
+	w := m.Writer(httptest.NewRecorder())
+	w.Write([]byte("abc"))
+	w.Write([]byte("de"))
+	if got := m.downloaded.Load(); got != 5 {
+		t.Errorf("downloaded = %d, want 5", got)
+	}
+
+	// 失败的传输不计入耗时，其他操作被忽略
+	m.Transfer("upload", time.Second, http.StatusOK)
+	m.Transfer("upload", time.Second, http.StatusInsufficientStorage)
+	m.Transfer("archive", 2*time.Second, http.StatusOK)
+	m.Transfer("delete", time.Second, http.StatusOK)
+	if h := m.durations["upload"]; h.count != 1 || h.sum != 1 {
+		t.Errorf("upload histogram = %+v", h)
+	}
+	if h := m.durations["download"]; h.count != 1 || h.sum != 2 {
+		t.Errorf("download histogram = %+v", h)
+	}
+
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"toolkit/utils"
+)
+
+const (
+	modeNormal   = ""
+	modeDropbox  = "dropbox"  // 只能上传文件，不能查看文件，也不能查看或提交文本
+	modeReadOnly = "readonly" // 只能查看及下载，不能修改
+)
+
+// 当前请求生效的模式，本机访问不受限制
+func clientMode(c *utils.Ctx) string {
+	if utils.IsLocalIP(c.ID) {
+		return modeNormal
+	}
+	return curConf().Mode
+}
+
+// 按服务模式检查请求所需的权限，被禁用时返回 403
+func modeAllowed(c *utils.Ctx, perm int) bool {
+	if perm <= 0 {
+		return true
+	}
+	var denied bool
+	mode := clientMode(c)
+	switch mode {
+	case modeDropbox:
+		// 文本板对所有访客共享，提交同样会被其他访客看到，投递箱模式下整体禁用
+		denied = perm&(permRead|permDelete|permShare) != 0 || isTextPath(c.R.URL.Path)
+	case modeReadOnly:
+		denied = perm&(permUpload|permDelete) != 0
+	}
+	if denied {
+		writeErrorRsp(c, http.StatusForbidden, "当前模式不允许该操作", nil, mode, c.R.Method, c.R.URL.Path)
+		return false
+	}
+	return true
+}
+
+func isTextPath(p string) bool {
+	return p == "/text" || strings.HasPrefix(p, "/text/")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"toolkit/utils"
+)
+
+func TestModeAllowed(t *testing.T) {
+	old := curConf()
+	t.Cleanup(func() { confPtr.Store(old) })
+
+	tests := []struct {
+		mode, ip, method, path string
+		perm                   int
+		want                   bool
+	}{
+		{modeDropbox, "10.0.0.1", http.MethodPost, "/upload", permUpload, true},
+		{modeDropbox, "10.0.0.1", http.MethodPost, "/mkdir", permUpload, true},
+		{modeDropbox, "10.0.0.1", http.MethodGet, "/list", permRead, false},
+		{modeDropbox, "10.0.0.1", http.MethodPost, "/text", permUpload, false},
+		{modeDropbox, "10.0.0.1", http.MethodPost, "/text/delete", permUpload | permDelete, false},
+		{modeDropbox, "10.0.0.1", http.MethodGet, "/info", permLogin, true},
+		{modeDropbox, "127.0.0.1", http.MethodPost, "/text", permUpload, true},
+		{modeReadOnly, "10.0.0.1", http.MethodGet, "/dl/a.txt", permRead, true},
+		{modeReadOnly, "10.0.0.1", http.MethodPost, "/text", permUpload, false},
+		{modeNormal, "10.0.0.1", http.MethodPost, "/text", permUpload, true},
+	}
+	for _, tt := range tests {
+		updateConfig(func(conf *Config) { conf.Mode = tt.mode })
+		w := httptest.NewRecorder()
+		c := &utils.Ctx{W: w, R: httptest.NewRequest(tt.method, tt.path, nil), ID: tt.ip}
+		if got := modeAllowed(c, tt.perm); got != tt.want {
+			t.Errorf("%q %s %s %s: modeAllowed = %t, want %t", tt.mode, tt.ip, tt.method, tt.path, got, tt.want)
+		}
+		if !tt.want && w.Code != http.StatusForbidden {
+			t.Errorf("%q %s: status %d", tt.mode, tt.path, w.Code)
+		}
+	}
+}
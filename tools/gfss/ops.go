@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"toolkit/utils"
+)
+
+type OpRsp struct {
+	From string `json:"from"`
+	Path string `json:"path"`
+}
+
+// 解析重命名、移动及复制的参数：path 为源文件或文件夹，
+// to 为目标文件夹（默认与源相同），name 为新名称（默认与源相同）
+// 源路径校验与删除相同，目标重名时按 name(n).ext 规则改名
+func resolveOp(c *utils.Ctx) (src, rel, dir, name string, info os.FileInfo, ok bool) {
+	q := c.R.URL.Query()
+	raw := q.Get("path")
+	src, rel, err := resolvePath(raw)
+	if err != nil || isProtectedPath(src) {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, raw)
+		return
+	}
+	info, err = os.Lstat(src)
+	if err != nil || utils.IsIgnoreFile(info) || (!info.IsDir() && !info.Mode().IsRegular()) {
+		writeErrorRsp(c, http.StatusNotFound, "文件不存在", err, rel)
+		return
+	}
+
+	dir = filepath.Dir(src)
+	if q.Has("to") {
+		var dirRel string
+		if dir, dirRel, err = resolvePath(q.Get("to")); err != nil {
+			writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, q.Get("to"))
+			return
+		}
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+			writeErrorRsp(c, http.StatusNotFound, "文件夹不存在", err, dirRel)
+			return
+		}
+		if info.IsDir() && isSubPath(src, dir) {
+			writeErrorRsp(c, http.StatusBadRequest, "不能移动或复制到自身的子文件夹", nil, rel, dirRel)
+			return
+		}
+	}
+
+	name = filepath.Base(src)
+	if q.Has("name") {
+		name = q.Get("name")
+		if !isValidUploadName(name) || strings.ContainsAny(name, `/\`) {
+			writeErrorRsp(c, http.StatusBadRequest, "非法文件名", nil, name)
+			return
+		}
+	}
+	return src, rel, dir, name, info, true
+}
+
+func relPath(fp string) string {
+	rel, _ := filepath.Rel(workDir, fp)
+	return filepath.ToSlash(rel)
+}
+
+// 重命名或移动，/rename 与 /move 参数相同
+func moveFile(c *utils.Ctx) {
+	src, rel, dir, name, info, ok := resolveOp(c)
+	if !ok {
+		return
+	}
+	if dlTracker.IsDownloading(rel) {
+		writeErrorRsp(c, http.StatusForbidden, "文件正在被下载", nil, rel)
+		return
+	}
+	if info.IsDir() && tfTracker.IsUploading(src) {
+		writeErrorRsp(c, http.StatusConflict, "文件夹中有文件正在上传", nil, rel)
+		return
+	}
+
+	dirWatcher.Lock()
+	defer dirWatcher.Unlock()
+
+	dst, err := moveNoConflict(src, dir, name, info)
+	if err != nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "移动文件失败", err, rel)
+		return
+	}
+	to := relPath(dst)
+	c.Info("r", rel, to)
+	if to != rel {
+		dirWatcher.Renamed(rel, to)
+	}
+
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(c.W).Encode(OpRsp{From: rel, Path: to})
+}
+
+func moveNoConflict(src, dir, name string, info os.FileInfo) (string, error) {
+	dst := filepath.Join(dir, name)
+	if dst == src {
+		return src, nil
+	}
+	// 不区分大小写的文件系统上仅修改大小写
+	if st, err := os.Lstat(dst); err == nil && strings.EqualFold(dst, src) && os.SameFile(info, st) {
+		return dst, os.Rename(src, dst)
+	}
+
+	rename := func(fp string) error {
+		err := os.Rename(src, fp)
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		// 跨分区时复制后删除
+		if _, _, err = copyTree(src, fp, nil); err != nil {
+			os.RemoveAll(fp)
+			return err
+		}
+		if err = os.RemoveAll(src); err != nil {
+			log.Error("删除源文件失败", err)
+		}
+		return nil
+	}
+
+	if info.IsDir() {
+		return reserveName(dir, name, func(fp string) error {
+			if _, err := os.Lstat(fp); err == nil {
+				return fs.ErrExist
+			}
+			return rename(fp)
+		})
+	}
+	// 先占用文件名，再覆盖占位文件
+	dst, err := reserveFileName(dir, name)
+	if err != nil {
+		return "", err
+	}
+	if err = rename(dst); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return dst, nil
+}
+
+// 复制文件或文件夹，参数与移动相同，复制到原文件夹时自动改名
+// 先复制到临时名称，完成后再改为最终名称，复制期间不阻塞目录监控，复制的数据计入配额
+func copyFile(c *utils.Ctx) {
+	src, rel, dir, name, info, ok := resolveOp(c)
+	if !ok {
+		return
+	}
+
+	// 复制期间不允许删除或移动源文件
+	dlTracker.Start(rel)
+	defer dlTracker.End(rel)
+
+	res, ok := storageMgr.Reserve(0)
+	if !ok {
+		writeErrorRsp(c, http.StatusInsufficientStorage, "超出存储配额", nil, utils.FormatBytesIEC(int64(curConf().Quota)))
+		return
+	}
+	defer res.Release()
+
+	tmp, err := stagePath(dir)
+	if err == nil && info.IsDir() {
+		err = os.Mkdir(tmp, 0o755)
+	}
+	var sums map[string]string
+	var size int64
+	if err == nil {
+		sums, size, err = copyTree(src, tmp, res)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		if errors.Is(err, errQuotaExceeded) {
+			writeErrorRsp(c, http.StatusInsufficientStorage, "超出存储配额", nil, utils.FormatBytesIEC(int64(curConf().Quota)))
+			return
+		}
+		writeErrorRsp(c, http.StatusInternalServerError, "复制文件失败", err, rel)
+		return
+	}
+
+	dirWatcher.Lock()
+	dst, err := moveNoConflict(tmp, dir, name, info)
+	if err != nil {
+		dirWatcher.Unlock()
+		os.RemoveAll(tmp)
+		writeErrorRsp(c, http.StatusInternalServerError, "复制文件失败", err, rel)
+		return
+	}
+	res.Commit(size)
+	to := relPath(dst)
+	dirWatcher.Added(to)
+	dirWatcher.Unlock()
+
+	// 复制时已计算摘要，记录到缓存及去重索引
+	for sub, sum := range sums {
+		indexFile(filepath.Join(dst, filepath.FromSlash(sub)), sum)
+	}
+	c.Info("c", rel, to)
+
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(c.W).Encode(OpRsp{From: rel, Path: to})
+}
+
+// 生成同一文件夹中的临时路径，列表、监控及清理均会忽略
+func stagePath(dir string) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, hex.EncodeToString(b[:])+tmpSuffix), nil
+}
+
+// 递归复制，跳过临时文件、程序文件及忽略的文件，保留文件修改时间
+// res 不为 nil 时复制的数据计入预留，返回各文件相对 dst 的路径及摘要，以及复制的总大小
+func copyTree(src, dst string, res *Reservation) (map[string]string, int64, error) {
+	sums := make(map[string]string)
+	var total int64
+	err := filepath.WalkDir(src, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fp != src && (isSidecarFile(fp) || strings.HasSuffix(d.Name(), tmpSuffix)) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if fp != src && utils.IsIgnoreFile(info) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, fp)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		n, sum, err := copyFileData(fp, target, res)
+		total += n
+		if err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = sum
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+	return sums, total, err
+}
+
+// 复制文件数据的同时计算摘要，res 不为 nil 时读取的数据计入预留
+func copyFileData(src, dst string, res *Reservation) (int64, string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, "", err
+	}
+	var r io.Reader = in
+	if res != nil {
+		r = res.Reader(in)
+	}
+	h := sha256.New()
+	buf := uploadBufPool.Get().([]byte)
+	n, err := io.CopyBuffer(io.MultiWriter(out, h), r, buf)
+	uploadBufPool.Put(buf)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), err
+}
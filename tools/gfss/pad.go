@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"toolkit/utils"
+	"unicode/utf8"
+)
+
+const defaultPad = "default"
+const maxPads = 32
+const maxPadName = 64
+
+// 每个文本板保留的历史记录数量，总大小不超过文本大小限制的 4 倍
+const maxPadHistory = 20
+
+var errPadNotFound = errors.New("文本板不存在")
+var errTooManyPads = errors.New("文本板数量超出限制")
+
+type TextSnippet struct {
+	Text string `json:"text"`
+	Time int64  `json:"time"` // 毫秒时间戳
+	IP   string `json:"ip"`
+}
+
+type TextPad struct {
+	Name    string        `json:"name"`
+	History []TextSnippet `json:"history"` // 最新的在前
+}
+
+type TextRsp struct {
+	Pad string `json:"pad"`
+	TextSnippet
+}
+
+type PadInfo struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+	Time int64  `json:"time"`
+	IP   string `json:"ip"`
+}
+
+// 多个命名文本板，每次提交保存为一条历史记录，修改合并后在后台持久化到程序所在目录
+type PadManager struct {
+	mux   sync.RWMutex
+	file  string
+	pads  map[string]*TextPad
+	saver *DelayedSaver
+}
+
+func NewPadManager(file string) *PadManager {
+	t := &PadManager{
+		file: file,
+		pads: make(map[string]*TextPad),
+	}
+	t.saver = NewDelayedSaver("文本板", t.save)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("读取文本板失败", err)
+		}
+		return t
+	}
+	var pads []*TextPad
+	if err = json.Unmarshal(b, &pads); err != nil {
+		log.Error("读取文本板失败", file, err)
+		return t
+	}
+	for _, pad := range pads {
+		if isValidPadName(pad.Name) {
+			t.pads[pad.Name] = pad
+		}
+	}
+	return t
+}
+
+func isValidPadName(name string) bool {
+	if name == "" || len(name) > maxPadName || !utf8.ValidString(name) {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// 当前内容，文本板不存在时返回空内容
+func (t *PadManager) Get(name string) TextSnippet {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if pad, ok := t.pads[name]; ok && len(pad.History) > 0 {
+		return pad.History[0]
+	}
+	return TextSnippet{}
+}
+
+func (t *PadManager) History(name string) ([]TextSnippet, error) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	pad, ok := t.pads[name]
+	if !ok {
+		return nil, errPadNotFound
+	}
+	return append([]TextSnippet{}, pad.History...), nil
+}
+
+// 写入新内容，与当前内容相同时不产生新的历史记录
+func (t *PadManager) Put(name string, s TextSnippet) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	pad, ok := t.pads[name]
+	if !ok {
+		if len(t.pads) >= maxPads {
+			return errTooManyPads
+		}
+		pad = &TextPad{Name: name}
+		t.pads[name] = pad
+	}
+	if len(pad.History) > 0 && pad.History[0].Text == s.Text {
+		return nil
+	}
+
+	pad.History = append([]TextSnippet{s}, pad.History...)
+	var size int64
+	for i, it := range pad.History {
+		size += int64(len(it.Text))
+		if i >= maxPadHistory || (i > 0 && size > 4*int64(curConf().MaxTextSize)) {
+			pad.History = pad.History[:i]
+			break
+		}
+	}
+	t.saver.Schedule()
+	return nil
+}
+
+func (t *PadManager) Delete(name string) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if _, ok := t.pads[name]; !ok {
+		return errPadNotFound
+	}
+	delete(t.pads, name)
+	t.saver.Schedule()
+	return nil
+}
+
+func (t *PadManager) List() []PadInfo {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	list := make([]PadInfo, 0, len(t.pads))
+	for _, pad := range t.pads {
+		info := PadInfo{Name: pad.Name}
+		if len(pad.History) > 0 {
+			info.Size = len(pad.History[0].Text)
+			info.Time = pad.History[0].Time
+			info.IP = pad.History[0].IP
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Time > list[j].Time
+	})
+	return list
+}
+
+// 程序退出前保存尚未写入的修改
+func (t *PadManager) Close() {
+	t.saver.Flush()
+}
+
+// 在读锁内序列化，锁外先写临时文件再替换，避免写入中途退出损坏数据
+func (t *PadManager) save() error {
+	t.mux.RLock()
+	pads := make([]*TextPad, 0, len(t.pads))
+	for _, pad := range t.pads {
+		pads = append(pads, pad)
+	}
+	b, err := json.Marshal(pads)
+	t.mux.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := t.file + tmpSuffix
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.file)
+}
+
+func padsFile() string {
+	return filepath.Join(filepath.Dir(execPath), "gfss_pads.json")
+}
+
+// 文本板名称来自 pad 参数，未指定时为 default
+func padName(c *utils.Ctx) (string, bool) {
+	name := c.R.URL.Query().Get("pad")
+	if name == "" {
+		name = defaultPad
+	}
+	if !isValidPadName(name) {
+		writeErrorRsp(c, http.StatusBadRequest, "无效文本板名称", nil, name)
+		return "", false
+	}
+	return name, true
+}
+
+// 提交文本，请求体为 {"text": "...", "pad": "..."}，pad 也可通过参数指定
+func modText(c *utils.Ctx) {
+	maxTextSize := int64(curConf().MaxTextSize)
+	tempBytes, err := io.ReadAll(http.MaxBytesReader(c.W, c.R.Body, maxTextSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeErrorRsp(c, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("文本超出%s限制", utils.FormatBytesIEC(maxTextSize)), err)
+			return
+		}
+		writeErrorRsp(c, http.StatusBadRequest, "参数错误", err)
+		return
+	}
+
+	var req struct {
+		Text string `json:"text"`
+		Pad  string `json:"pad"`
+	}
+	if err = json.Unmarshal(tempBytes, &req); err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "参数错误", err)
+		return
+	}
+	name, ok := padName(c)
+	if !ok {
+		return
+	}
+	if req.Pad != "" {
+		if name = req.Pad; !isValidPadName(name) {
+			writeErrorRsp(c, http.StatusBadRequest, "无效文本板名称", nil, name)
+			return
+		}
+	}
+
+	rsp := TextRsp{
+		Pad: name,
+		TextSnippet: TextSnippet{
+			Text: req.Text,
+			Time: time.Now().UnixMilli(),
+			IP:   c.ID,
+		},
+	}
+	if err = padMgr.Put(name, rsp.TextSnippet); err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "文本板数量超出限制", err, name)
+		return
+	}
+	c.Info(name, utils.FormatBytesIEC(int64(len(req.Text))))
+	sum := sha256.Sum256([]byte(req.Text))
+	audit(c, "text", name, int64(len(req.Text)), 0, http.StatusOK, hex.EncodeToString(sum[:]))
+	sseMgr.Broadcast("text", rsp)
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+}
+
+func text(c *utils.Ctx) {
+	name, ok := padName(c)
+	if !ok {
+		return
+	}
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.W.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(c.W).Encode(TextRsp{Pad: name, TextSnippet: padMgr.Get(name)})
+}
+
+func textHistory(c *utils.Ctx) {
+	name, ok := padName(c)
+	if !ok {
+		return
+	}
+	list, err := padMgr.History(name)
+	if err != nil {
+		writeErrorRsp(c, http.StatusNotFound, "文本板不存在", err, name)
+		return
+	}
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.W.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(c.W).Encode(list)
+}
+
+func listPads(c *utils.Ctx) {
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.W.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(c.W).Encode(padMgr.List())
+}
+
+func delPad(c *utils.Ctx) {
+	name, ok := padName(c)
+	if !ok {
+		return
+	}
+	if err := padMgr.Delete(name); err != nil {
+		writeErrorRsp(c, http.StatusNotFound, "文本板不存在", err, name)
+		return
+	}
+	c.Info("d", name)
+	audit(c, "text-delete", name, 0, 0, http.StatusOK, "")
+	sseMgr.Broadcast("padRemoved", name)
+}
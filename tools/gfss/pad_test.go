@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestPadManager(t *testing.T) {
+	old := curConf()
+	t.Cleanup(func() { confPtr.Store(old) })
+	confPtr.Store(defaultConfig())
+
+	file := filepath.Join(t.TempDir(), "pads.json")
+	mgr := NewPadManager(file)
+	for i := range maxPadHistory + 5 {
+		if err := mgr.Put(defaultPad, TextSnippet{Text: strconv.Itoa(i), Time: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 与当前内容相同时不产生新记录
+	mgr.Put(defaultPad, TextSnippet{Text: strconv.Itoa(maxPadHistory + 4)})
+	history, err := mgr.History(defaultPad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != maxPadHistory || history[0].Text != strconv.Itoa(maxPadHistory+4) {
+		t.Errorf("history = %d entries, latest %q", len(history), history[0].Text)
+	}
+
+	for i := 1; i < maxPads; i++ {
+		if err := mgr.Put("pad"+strconv.Itoa(i), TextSnippet{Text: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mgr.Put("extra", TextSnippet{Text: "x"}); !errors.Is(err, errTooManyPads) {
+		t.Errorf("Put over limit = %v", err)
+	}
+	if err := mgr.Delete("pad1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Delete("pad1"); !errors.Is(err, errPadNotFound) {
+		t.Errorf("Delete twice = %v", err)
+	}
+
+	// 退出时保存，重新加载后内容一致
+	mgr.Close()
+	mgr = NewPadManager(file)
+	if got := mgr.Get(defaultPad).Text; got != strconv.Itoa(maxPadHistory+4) {
+		t.Errorf("Get after reload = %q", got)
+	}
+	if n := len(mgr.List()); n != maxPads-1 {
+		t.Errorf("List after reload = %d pads", n)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"toolkit/utils"
+)
+
+var errInvalidPath = errors.New("invalid path")
+
+// 将请求中以 / 分隔的相对路径解析为工作目录下的绝对路径
+// 拒绝绝对路径、..、临时文件，以及通过符号链接逃逸工作目录的路径
+// 返回绝对路径及规范化后的相对路径（工作目录本身为空字符串）
+func resolvePath(raw string) (fp string, rel string, err error) {
+	raw = strings.Trim(raw, "/")
+	if raw == "" {
+		return workDir, "", nil
+	}
+
+	local := filepath.FromSlash(raw)
+	if !filepath.IsLocal(local) {
+		return "", "", errInvalidPath
+	}
+	for _, name := range strings.Split(filepath.ToSlash(local), "/") {
+		if name == "" || name == "." || name == ".." ||
+			strings.HasSuffix(name, tmpSuffix) {
+			return "", "", errInvalidPath
+		}
+	}
+
+	root, err := filepath.EvalSymlinks(workDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	fp = filepath.Join(workDir, local)
+	if isSidecarFile(fp) {
+		return "", "", errInvalidPath
+	}
+	// 目标可能尚不存在（如新建文件夹），逐级向上找到已存在的部分检查真实路径
+	for probe := fp; ; probe = filepath.Dir(probe) {
+		real, err := filepath.EvalSymlinks(probe)
+		if err == nil {
+			if !isSubPath(root, real) {
+				return "", "", errInvalidPath
+			}
+			break
+		}
+		if !os.IsNotExist(err) || probe == workDir {
+			return "", "", err
+		}
+	}
+
+	return fp, filepath.ToSlash(filepath.Clean(local)), nil
+}
+
+func isSubPath(root, fp string) bool {
+	rel, err := filepath.Rel(root, fp)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
+
+// 路径本身是程序文件或包含程序文件时不允许修改
+func isProtectedPath(fp string) bool {
+	return fp == workDir || isSidecarFile(fp) || isSubPath(fp, execPath)
+}
+
+// 程序及其配置、用户、证书、日志、文本板、索引等附属文件
+// 工作目录包含程序目录时，这些文件不出现在列表、打包及 WebDAV 中，也不能被访问或修改
+var sidecarFiles = make(map[string]bool)
+
+// 启动时登记附属文件，之后只读
+func addSidecarFiles(files ...string) {
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		if fp, err := filepath.Abs(utils.NormalizePath(f)); err == nil {
+			sidecarFiles[sidecarKey(fp)] = true
+		}
+	}
+}
+
+func isSidecarFile(fp string) bool {
+	return sidecarFiles[sidecarKey(fp)]
+}
+
+func sidecarKey(fp string) string {
+	fp = filepath.Clean(fp)
+	if runtime.GOOS == "windows" {
+		fp = strings.ToLower(fp)
+	}
+	return fp
+}
+
+type ListEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type Crumb struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type ListRsp struct {
+	Path    string      `json:"path"`
+	Parents []Crumb     `json:"parents"`
+	Entries []ListEntry `json:"entries"`
+}
+
+// 按路径列出文件夹内容，文件夹在前
+func listPath(c *utils.Ctx) {
+	dir, rel, err := resolvePath(c.R.URL.Query().Get("path"))
+	if err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, c.R.URL.Query().Get("path"))
+		return
+	}
+
+	list, err := readDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeErrorRsp(c, http.StatusNotFound, "文件夹不存在", err, rel)
+		} else {
+			writeErrorRsp(c, http.StatusInternalServerError, "获取文件失败", err, rel)
+		}
+		return
+	}
+
+	rsp := ListRsp{
+		Path:    rel,
+		Parents: crumbs(rel),
+		Entries: make([]ListEntry, 0, len(list)),
+	}
+	for _, it := range list {
+		if it.isDir {
+			rsp.Entries = append(rsp.Entries, ListEntry{Name: it.name, Type: "dir"})
+		}
+	}
+	for _, it := range list {
+		if !it.isDir {
+			rsp.Entries = append(rsp.Entries, ListEntry{Name: it.name, Type: "file"})
+		}
+	}
+
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(c.W).Encode(rsp)
+}
+
+// 相对路径逐级拆分为面包屑
+func crumbs(rel string) []Crumb {
+	list := make([]Crumb, 0)
+	if rel == "" {
+		return list
+	}
+	names := strings.Split(rel, "/")
+	for i, name := range names {
+		list = append(list, Crumb{
+			Name: name,
+			Path: strings.Join(names[:i+1], "/"),
+		})
+	}
+	return list
+}
+
+func mkdir(c *utils.Ctx) {
+	raw := c.R.URL.Query().Get("path")
+	fp, rel, err := resolvePath(raw)
+	if err != nil || rel == "" {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, raw)
+		return
+	}
+
+	if info, err := os.Stat(fp); err == nil && !info.IsDir() {
+		writeErrorRsp(c, http.StatusConflict, "同名文件已存在", nil, rel)
+		return
+	}
+
+	if err = os.MkdirAll(fp, 0o755); err != nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "创建文件夹失败", err, rel)
+		return
+	}
+	c.Info("m", rel)
+}
+
+// 解析 path 查询参数指定的上传目录
+func resolveUploadDir(c *utils.Ctx) (string, bool) {
+	raw := c.R.URL.Query().Get("path")
+	dir, rel, err := resolvePath(raw)
+	if err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, raw)
+		return "", false
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		writeErrorRsp(c, http.StatusNotFound, "文件夹不存在", err, rel)
+		return "", false
+	}
+	return dir, true
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"toolkit/utils"
+	"toolkit/utils/qr"
+
+	"github.com/amalfra/etag/v3"
+)
+
+// 二维码图片每个模块的像素数
+const qrScale = 8
+
+// 局域网访问地址，启动时确定
+var siteURL string
+
+var qrOnce sync.Once
+var qrData []byte
+var qrETag string
+
+// 启动时在终端打印访问地址的二维码
+func printQR() {
+	code, err := qr.Encode([]byte(siteURL), qr.M)
+	if err != nil {
+		return
+	}
+	fmt.Print(code.Terminal())
+}
+
+// 访问地址的二维码图片，供手机扫码打开
+func qrCode(c *utils.Ctx) {
+	qrOnce.Do(func() {
+		code, err := qr.Encode([]byte(siteURL), qr.M)
+		if err == nil {
+			qrData, err = code.PNG(qrScale)
+		}
+		if err != nil {
+			log.Error("生成二维码失败", err)
+			return
+		}
+		qrETag = etag.Generate(string(qrData), true)
+	})
+	if qrData == nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "生成二维码失败", nil, siteURL)
+		return
+	}
+	if c.R.Header.Get("If-None-Match") == qrETag {
+		c.W.WriteHeader(http.StatusNotModified)
+		return
+	}
+	c.W.Header().Set("Content-Type", "image/png")
+	c.W.Header().Set("ETag", qrETag)
+	c.W.Write(qrData)
+}
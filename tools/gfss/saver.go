@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 合并修改后的保存等待时长
+const saveDelay = 2 * time.Second
+
+// 合并短时间内的多次修改，延迟后在后台保存，避免每次修改都在锁内重写整个文件
+type DelayedSaver struct {
+	mux     sync.Mutex
+	saving  sync.Mutex // 保存期间持有，Flush 等待进行中的保存完成
+	name    string
+	save    func() error
+	timer   *time.Timer
+	pending bool
+}
+
+func NewDelayedSaver(name string, save func() error) *DelayedSaver {
+	return &DelayedSaver{name: name, save: save}
+}
+
+// 标记有修改，saveDelay 后保存，期间的修改合并为一次
+func (s *DelayedSaver) Schedule() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.pending {
+		return
+	}
+	s.pending = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(saveDelay, s.run)
+	} else {
+		s.timer.Reset(saveDelay)
+	}
+}
+
+// 立即保存尚未保存的修改，程序退出前调用
+func (s *DelayedSaver) Flush() {
+	s.mux.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mux.Unlock()
+	s.run()
+}
+
+func (s *DelayedSaver) run() {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+	s.mux.Lock()
+	pending := s.pending
+	s.pending = false
+	s.mux.Unlock()
+	if !pending {
+		return
+	}
+	if err := s.save(); err != nil {
+		log.Error("保存"+s.name+"失败", err)
+	}
+}
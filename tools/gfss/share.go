@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"toolkit/utils"
+)
+
+const defaultShareTTL = 24 * time.Hour
+const maxShareTTL = 30 * 24 * time.Hour
+
+type ShareLink struct {
+	Token    string `json:"token"`
+	URL      string `json:"url"`
+	Path     string `json:"path"`
+	ExpireAt string `json:"expireAt"`
+	MaxCount int    `json:"maxCount"`
+	Count    int    `json:"count"`
+	Creator  string `json:"creator"`
+	expireAt time.Time
+	clients  map[string]bool // 已计入次数的客户端
+}
+
+// 保存到文件的分享链接
+type savedShare struct {
+	Token    string   `json:"token"`
+	Path     string   `json:"path"`
+	ExpireAt int64    `json:"expireAt"` // 毫秒时间戳
+	MaxCount int      `json:"maxCount"`
+	Count    int      `json:"count"`
+	Creator  string   `json:"creator"`
+	Clients  []string `json:"clients,omitempty"`
+}
+
+// 分享链接管理，修改合并后在后台保存到程序所在目录，重启后仍然有效
+type ShareManager struct {
+	mux   sync.Mutex
+	file  string
+	links map[string]*ShareLink
+	saver *DelayedSaver
+}
+
+// 读取保存的分享链接，需在会话密钥初始化后调用，签名不匹配或已过期的链接被丢弃
+func NewShareManager(file string) *ShareManager {
+	t := &ShareManager{
+		file:  file,
+		links: make(map[string]*ShareLink),
+	}
+	t.saver = NewDelayedSaver("分享链接", t.save)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("读取分享链接失败", err)
+		}
+		return t
+	}
+	var list []savedShare
+	if err = json.Unmarshal(b, &list); err != nil {
+		log.Error("读取分享链接失败", file, err)
+		return t
+	}
+	now := time.Now()
+	for _, v := range list {
+		id, _, _ := strings.Cut(v.Token, ".")
+		expireAt := time.UnixMilli(v.ExpireAt)
+		if !hmac.Equal([]byte(signShare(id)), []byte(v.Token)) || now.After(expireAt) {
+			continue
+		}
+		link := &ShareLink{
+			Token:    v.Token,
+			Path:     v.Path,
+			ExpireAt: expireAt.Format("2006-01-02 15:04:05"),
+			MaxCount: v.MaxCount,
+			Count:    v.Count,
+			Creator:  v.Creator,
+			expireAt: expireAt,
+			clients:  make(map[string]bool),
+		}
+		for _, ip := range v.Clients {
+			link.clients[ip] = true
+		}
+		t.links[link.Token] = link
+	}
+	return t
+}
+
+// 分享令牌格式：随机ID.HMAC签名，防止伪造及枚举
+func signShare(id string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("share|" + id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (t *ShareManager) Create(rel, creator string, ttl time.Duration, maxCount int) (ShareLink, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ShareLink{}, err
+	}
+	expireAt := time.Now().Add(ttl)
+	link := &ShareLink{
+		Token:    signShare(hex.EncodeToString(b[:])),
+		Path:     rel,
+		ExpireAt: expireAt.Format("2006-01-02 15:04:05"),
+		MaxCount: maxCount,
+		Creator:  creator,
+		expireAt: expireAt,
+		clients:  make(map[string]bool),
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.expire()
+	t.links[link.Token] = link
+	t.saver.Schedule()
+	return *link, nil
+}
+
+// 校验令牌并返回文件路径，每个客户端首次下载时占用一次下载次数，之后的续传及重复下载不再计数
+// reserved 表示本次新占用了次数，未发送文件内容时需调用 Cancel 退回
+func (t *ShareManager) Use(token, client string) (rel string, reserved bool, ok bool) {
+	id, _, _ := strings.Cut(token, ".")
+	if !hmac.Equal([]byte(signShare(id)), []byte(token)) {
+		return "", false, false
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	link, ok := t.links[token]
+	if !ok {
+		return "", false, false
+	}
+	if time.Now().After(link.expireAt) {
+		delete(t.links, token)
+		t.saver.Schedule()
+		return "", false, false
+	}
+	if link.clients[client] {
+		return link.Path, false, true
+	}
+	if link.MaxCount > 0 && link.Count >= link.MaxCount {
+		return "", false, false
+	}
+	link.Count++
+	link.clients[client] = true
+	t.saver.Schedule()
+	return link.Path, true, true
+}
+
+// 退回 Use 占用的下载次数
+func (t *ShareManager) Cancel(token, client string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	link, ok := t.links[token]
+	if !ok || !link.clients[client] {
+		return
+	}
+	link.Count--
+	delete(link.clients, client)
+	t.saver.Schedule()
+}
+
+func (t *ShareManager) Revoke(token string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	_, ok := t.links[token]
+	if ok {
+		delete(t.links, token)
+		t.saver.Schedule()
+	}
+	return ok
+}
+
+// 文件或所在文件夹被重命名、移动后，分享链接指向新路径
+func (t *ShareManager) Rename(from, to string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for _, link := range t.links {
+		if link.Path == from || strings.HasPrefix(link.Path, from+"/") {
+			link.Path = to + strings.TrimPrefix(link.Path, from)
+			t.saver.Schedule()
+		}
+	}
+}
+
+func (t *ShareManager) List() []ShareLink {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.expire()
+	list := make([]ShareLink, 0, len(t.links))
+	for _, link := range t.links {
+		list = append(list, *link)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].expireAt.Before(list[j].expireAt)
+	})
+	return list
+}
+
+func (t *ShareManager) expire() {
+	var now = time.Now()
+	for token, link := range t.links {
+		if now.After(link.expireAt) {
+			delete(t.links, token)
+			t.saver.Schedule()
+		}
+	}
+}
+
+// 程序退出前保存尚未写入的修改
+func (t *ShareManager) Close() {
+	t.saver.Flush()
+}
+
+// 在锁内序列化，锁外先写临时文件再替换
+func (t *ShareManager) save() error {
+	t.mux.Lock()
+	list := make([]savedShare, 0, len(t.links))
+	for _, link := range t.links {
+		v := savedShare{
+			Token:    link.Token,
+			Path:     link.Path,
+			ExpireAt: link.expireAt.UnixMilli(),
+			MaxCount: link.MaxCount,
+			Count:    link.Count,
+			Creator:  link.Creator,
+		}
+		for ip := range link.clients {
+			v.Clients = append(v.Clients, ip)
+		}
+		list = append(list, v)
+	}
+	t.mux.Unlock()
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tmp := t.file + tmpSuffix
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.file)
+}
+
+func sharesFile() string {
+	return filepath.Join(filepath.Dir(execPath), "gfss_shares.json")
+}
+
+func shareURL(c *utils.Ctx, token string) string {
+	return scheme + "://" + c.R.Host + "/s/" + token
+}
+
+// 生成分享链接，参数 path 为文件路径，ttl 为有效期（如 2h、30m，默认 24h），max 为最大下载次数（0 不限）
+func createShare(c *utils.Ctx) {
+	raw := c.R.FormValue("path")
+	fp, rel, err := resolvePath(raw)
+	if err != nil || rel == "" {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, raw)
+		return
+	}
+	info, err := os.Stat(fp)
+	if err != nil || !info.Mode().IsRegular() || utils.IsIgnoreFile(info) {
+		writeErrorRsp(c, http.StatusNotFound, "文件不存在", err, rel)
+		return
+	}
+
+	ttl := defaultShareTTL
+	if v := c.R.FormValue("ttl"); v != "" {
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl <= 0 || ttl > maxShareTTL {
+			writeErrorRsp(c, http.StatusBadRequest, "无效有效期", err, v)
+			return
+		}
+	}
+
+	var maxCount int
+	if v := c.R.FormValue("max"); v != "" {
+		maxCount, err = strconv.Atoi(v)
+		if err != nil || maxCount < 0 {
+			writeErrorRsp(c, http.StatusBadRequest, "无效下载次数", err, v)
+			return
+		}
+	}
+
+	link, err := shareMgr.Create(rel, c.ID, ttl, maxCount)
+	if err != nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "生成分享链接失败", err, rel)
+		return
+	}
+	link.URL = shareURL(c, link.Token)
+	c.Info("s", rel, ttl, maxCount)
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(c.W).Encode(link)
+}
+
+func listShares(c *utils.Ctx) {
+	list := shareMgr.List()
+	for i := range list {
+		list[i].URL = shareURL(c, list[i].Token)
+	}
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(c.W).Encode(list)
+}
+
+func revokeShare(c *utils.Ctx) {
+	token := c.R.FormValue("token")
+	if !shareMgr.Revoke(token) {
+		writeErrorRsp(c, http.StatusNotFound, "分享链接不存在", nil, token)
+		return
+	}
+	c.Info("r", token)
+}
+
+// 通过分享链接下载，按客户端 IP 计入下载次数，开始发送文件内容时占用，
+// 已计入的客户端在有效期内可以续传或重新下载，HEAD、304 及失败的请求不消耗次数
+func shareDownload(c *utils.Ctx) {
+	token := strings.TrimPrefix(c.R.URL.Path, "/s/")
+	// 多区间响应无法按区间续传，且会绕过单个客户端的下载统计
+	if strings.Contains(c.R.Header.Get("Range"), ",") {
+		writeErrorRsp(c, http.StatusRequestedRangeNotSatisfiable, "不支持多区间下载", nil, token)
+		return
+	}
+	rel, reserved, ok := shareMgr.Use(token, c.ID)
+	if !ok {
+		writeErrorRsp(c, http.StatusNotFound, "分享链接无效或已过期", nil, token)
+		return
+	}
+	if !serveFile(c, rel, false) && reserved {
+		shareMgr.Cancel(token, c.ID)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"toolkit/utils"
+)
+
+func TestSignShare(t *testing.T) {
+	sessionKey = []byte("test-key")
+	token := signShare("abc")
+	id, sig, ok := strings.Cut(token, ".")
+	if !ok || id != "abc" || sig == "" {
+		t.Fatalf("signShare = %q", token)
+	}
+	if signShare("abc") != token {
+		t.Error("signShare not deterministic")
+	}
+	if signShare("abd") == token {
+		t.Error("different ids share a signature")
+	}
+}
+
+func TestShareManagerUse(t *testing.T) {
+	sessionKey = []byte("test-key")
+	mgr := NewShareManager(filepath.Join(t.TempDir(), "shares.json"))
+	link, err := mgr.Create("a/b.txt", "127.0.0.1", time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, _ := strings.Cut(link.Token, ".")
+	for _, token := range []string{"", id, id + ".", id + ".AAAA", signShare("0123")} {
+		if _, _, ok := mgr.Use(token, "10.0.0.1"); ok {
+			t.Errorf("Use(%q) accepted", token)
+		}
+	}
+
+	steps := []struct {
+		client   string
+		reserved bool
+		ok       bool
+	}{
+		{"10.0.0.1", true, true},
+		{"10.0.0.1", false, true}, // 续传不重复计数
+		{"10.0.0.2", true, true},
+		{"10.0.0.3", false, false}, // 次数已用完
+		{"10.0.0.2", false, true},  // 已计入的客户端仍可续传
+	}
+	for i, st := range steps {
+		rel, reserved, ok := mgr.Use(link.Token, st.client)
+		if ok != st.ok || reserved != st.reserved || (ok && rel != "a/b.txt") {
+			t.Errorf("step %d: Use(%s) = %q %t %t, want reserved %t ok %t",
+				i, st.client, rel, reserved, ok, st.reserved, st.ok)
+		}
+	}
+
+	// 未开始下载时退回次数
+	mgr.Cancel(link.Token, "10.0.0.2")
+	if _, reserved, ok := mgr.Use(link.Token, "10.0.0.3"); !ok || !reserved {
+		t.Error("count not returned by Cancel")
+	}
+	if list := mgr.List(); len(list) != 1 || list[0].Count != 2 {
+		t.Errorf("list = %+v", list)
+	}
+
+	// 过期
+	expired, _ := mgr.Create("c.txt", "", -time.Second, 0)
+	if _, _, ok := mgr.Use(expired.Token, "10.0.0.1"); ok {
+		t.Error("expired link accepted")
+	}
+
+	// 不限次数
+	unlimited, _ := mgr.Create("d.txt", "", time.Hour, 0)
+	for i := range 5 {
+		if _, _, ok := mgr.Use(unlimited.Token, fmt.Sprintf("10.0.1.%d", i)); !ok {
+			t.Fatal("unlimited link rejected")
+		}
+	}
+
+	mgr.Rename("d.txt", "e/d.txt")
+	if rel, _, _ := mgr.Use(unlimited.Token, "10.0.0.1"); rel != "e/d.txt" {
+		t.Errorf("Use after rename = %q", rel)
+	}
+}
+
+func TestShareManagerUseConcurrent(t *testing.T) {
+	sessionKey = []byte("test-key")
+	mgr := NewShareManager(filepath.Join(t.TempDir(), "shares.json"))
+	link, _ := mgr.Create("a.txt", "", time.Hour, 3)
+
+	var wg sync.WaitGroup
+	var granted atomic.Int32
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, ok := mgr.Use(link.Token, fmt.Sprintf("10.0.0.%d", i)); ok {
+				granted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := granted.Load(); n != 3 {
+		t.Errorf("granted %d downloads, want 3", n)
+	}
+}
+
+func TestShareDownloadMultiRange(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/s/abc.def", nil)
+	r.Header.Set("Range", "bytes=0-10,11-")
+	shareDownload(&utils.Ctx{W: w, R: r, ID: "10.0.0.1"})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("status = %d", w.Code)
+	}
+}
+
+func TestShareManagerPersist(t *testing.T) {
+	sessionKey = []byte("test-key")
+	file := filepath.Join(t.TempDir(), "shares.json")
+	mgr := NewShareManager(file)
+	link, _ := mgr.Create("a.txt", "127.0.0.1", time.Hour, 2)
+	mgr.Create("b.txt", "", -time.Second, 0)
+	mgr.Use(link.Token, "10.0.0.1")
+	mgr.Close()
+
+	// 重启后次数及已计入的客户端保留，过期的链接丢弃
+	mgr = NewShareManager(file)
+	if list := mgr.List(); len(list) != 1 || list[0].Path != "a.txt" || list[0].Count != 1 || list[0].ExpireAt != link.ExpireAt {
+		t.Fatalf("restored = %+v", list)
+	}
+	if _, reserved, ok := mgr.Use(link.Token, "10.0.0.1"); !ok || reserved {
+		t.Errorf("restored client: reserved %t ok %t", reserved, ok)
+	}
+
+	// 会话密钥变化后签名不匹配的链接丢弃
+	sessionKey = []byte("other-key")
+	if list := NewShareManager(file).List(); len(list) != 0 {
+		t.Errorf("links with old key restored: %+v", list)
+	}
+}
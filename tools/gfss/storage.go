@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"toolkit/utils"
+
+	"github.com/hymkor/trash-go"
+)
+
+// 定期清理的间隔
+const janitorInterval = 10 * time.Minute
+
+// 配额不足时距上次扫描超过该时长则重新统计
+const quotaRescanInterval = 5 * time.Second
+
+// 流式上传时每次追加预留的大小
+const quotaChunk = 4 << 20
+
+var errQuotaExceeded = errors.New("超出存储配额")
+
+type storedFile struct {
+	fp      string
+	rel     string
+	size    int64
+	modTime time.Time
+}
+
+// 工作目录存储管理：后台按保留天数及总大小清理旧文件，上传时按配额预留空间
+type StorageManager struct {
+	mux      sync.Mutex
+	used     int64 // 上次统计的总大小（不含临时文件）加上之后保存的大小
+	reserved int64 // 进行中的上传预留的大小
+	scanned  time.Time
+	kick     chan struct{}
+}
+
+func NewStorageManager() *StorageManager {
+	return &StorageManager{
+		kick: make(chan struct{}, 1),
+	}
+}
+
+func (t *StorageManager) Run() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		t.clean()
+		select {
+		case <-ticker.C:
+		case <-t.kick:
+		}
+	}
+}
+
+// 预留 n 字节，超出配额时返回 false，用完需 Release
+func (t *StorageManager) Reserve(n int64) (*Reservation, bool) {
+	r := &Reservation{mgr: t}
+	if !r.Grow(max(n, 0)) {
+		return nil, false
+	}
+	return r, true
+}
+
+func (t *StorageManager) grow(n int64) bool {
+	quota := int64(curConf().Quota)
+	t.mux.Lock()
+	if quota <= 0 || t.used+t.reserved+n <= quota {
+		t.reserved += n
+		t.mux.Unlock()
+		return true
+	}
+	// 统计值只增不减，超出时重新统计以计入已删除的文件，同一时间只有一个请求统计
+	if time.Since(t.scanned) <= quotaRescanInterval {
+		t.mux.Unlock()
+		return false
+	}
+	t.scanned = time.Now()
+	t.mux.Unlock()
+
+	// 与 clean 相同，遍历期间不持有锁，避免阻塞其他上传
+	used := usedSize(workDir)
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.used = used
+	if t.used+t.reserved+n > quota {
+		return false
+	}
+	t.reserved += n
+	return true
+}
+
+// 将预留的 n 字节转为已占用，超出总大小上限时提前清理
+func (t *StorageManager) commit(n int64) {
+	t.mux.Lock()
+	t.reserved -= n
+	t.used += n
+	limit := int64(curConf().MaxTotalSize)
+	over := limit > 0 && t.used > limit
+	t.mux.Unlock()
+	if over {
+		t.Kick()
+	}
+}
+
+func (t *StorageManager) release(n int64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.reserved -= n
+}
+
+// 一次上传预留的空间，size 为预留大小，pending 为已读取但尚未保存或丢弃的大小
+// 同一预留不能同时在多个协程中使用
+type Reservation struct {
+	mgr     *StorageManager
+	size    int64
+	pending int64
+}
+
+// 追加预留 n 字节
+func (r *Reservation) Grow(n int64) bool {
+	if !r.mgr.grow(n) {
+		return false
+	}
+	r.size += n
+	return true
+}
+
+// 包装请求体，读取的数据超出预留时追加预留，配额不足时返回 errQuotaExceeded
+func (r *Reservation) Reader(src io.Reader) io.Reader {
+	return &quotaReader{Reader: src, res: r}
+}
+
+// 读取的 n 字节已保存为文件
+func (r *Reservation) Commit(n int64) {
+	r.pending = max(r.pending-n, 0)
+	r.size -= n
+	r.mgr.commit(n)
+}
+
+// 读取的 n 字节未保存，如去重命中或上传失败，空间可用于之后的数据
+func (r *Reservation) Discard(n int64) {
+	r.pending = max(r.pending-n, 0)
+}
+
+// 释放剩余的预留，nil 时忽略
+func (r *Reservation) Release() {
+	if r == nil || r.size == 0 {
+		return
+	}
+	r.mgr.release(r.size)
+	r.size, r.pending = 0, 0
+}
+
+type quotaReader struct {
+	io.Reader
+	res *Reservation
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	res := r.res
+	res.pending += int64(n)
+	// 按块追加预留，配额不足时再尝试只追加实际需要的部分
+	if over := res.pending - res.size; over > 0 && !res.Grow(max(over, quotaChunk)) && !res.Grow(over) {
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
+
+// 立即执行一次清理
+func (t *StorageManager) Kick() {
+	select {
+	case t.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (t *StorageManager) clean() {
+	root := workDir
+	files, total, partial := scanFiles(root)
+	t.mux.Lock()
+	t.used, t.scanned = total-partial, time.Now()
+	t.mux.Unlock()
+
+	conf := curConf()
+	days, limit := conf.RetentionDays, int64(conf.MaxTotalSize)
+	if days <= 0 && limit <= 0 {
+		return
+	}
+
+	// 最旧的在前
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	var count int
+	var freed int64
+	cutoff := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	for _, f := range files {
+		expired := days > 0 && f.modTime.Before(cutoff)
+		if !expired && (limit <= 0 || total <= limit) {
+			break
+		}
+		if dlTracker.IsDownloading(f.rel) {
+			continue
+		}
+		if err := removeFile(f.fp); err != nil {
+			log.Error("清理文件失败", f.rel, err)
+			continue
+		}
+		removeEmptyParents(root, f.fp)
+		if expired {
+			log.Info("清理过期文件", f.rel, f.modTime.Format("2006-01-02 15:04:05"))
+		} else {
+			log.Info("清理旧文件", f.rel, utils.FormatBytesIEC(f.size))
+		}
+		dedupIdx.Remove(f.rel)
+		audit(nil, "clean", f.rel, f.size, 0, http.StatusOK, "")
+		total -= f.size
+		freed += f.size
+		count++
+	}
+	if count == 0 {
+		return
+	}
+	t.mux.Lock()
+	t.used -= freed
+	t.mux.Unlock()
+	log.Infof("已清理 %d 个文件，释放 %s，当前占用 %s", count,
+		utils.FormatBytesIEC(freed), utils.FormatBytesIEC(total))
+}
+
+func removeFile(fp string) error {
+	if curConf().Trash {
+		return trash.Throw(fp)
+	}
+	return os.Remove(fp)
+}
+
+// 清理文件后逐级删除变空的上级文件夹，不删除工作目录本身，文件夹不为空时停止
+func removeEmptyParents(root, fp string) {
+	for dir := filepath.Dir(fp); dir != root && isSubPath(root, dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// 统计工作目录已保存文件占用的空间，未完成上传的临时文件已计入预留，不重复统计
+func usedSize(root string) int64 {
+	_, total, partial := scanFiles(root)
+	return total - partial
+}
+
+// 列出可清理的文件，跳过临时文件、忽略的文件及程序目录中的文件
+// total 为所有文件的总大小，partial 为其中临时文件的大小
+func scanFiles(root string) (files []storedFile, total, partial int64) {
+	filepath.WalkDir(root, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || fp == root || isSidecarFile(fp) {
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			// 复制中的临时文件夹已计入预留
+			if utils.IsIgnoreFile(info) || strings.HasSuffix(d.Name(), tmpSuffix) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		total += info.Size()
+		if strings.HasSuffix(d.Name(), tmpSuffix) {
+			partial += info.Size()
+			return nil
+		}
+		// 工作目录包含程序目录时不清理其中的配置等文件
+		if utils.IsIgnoreFile(info) ||
+			filepath.Dir(fp) == filepath.Dir(execPath) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, fp)
+		if err != nil {
+			return nil
+		}
+		files = append(files, storedFile{
+			fp:      fp,
+			rel:     filepath.ToSlash(rel),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	return
+}
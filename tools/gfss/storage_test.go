@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoveEmptyParents(t *testing.T) {
+	root := t.TempDir()
+	for _, d := range []string{"a/b/c", "a/keep"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(d)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fp := filepath.Join(root, "a", "b", "c", "f.txt")
+	removeEmptyParents(root, fp)
+	for d, want := range map[string]bool{"a/b/c": false, "a/b": false, "a": true, "a/keep": true} {
+		_, err := os.Stat(filepath.Join(root, filepath.FromSlash(d)))
+		if (err == nil) != want {
+			t.Errorf("%s exists = %t, want %t", d, err == nil, want)
+		}
+	}
+
+	// 文件直接在工作目录中时不删除工作目录
+	removeEmptyParents(root, filepath.Join(root, "f.txt"))
+	if _, err := os.Stat(root); err != nil {
+		t.Error("root removed")
+	}
+}
+
+func TestStorageQuotaRescan(t *testing.T) {
+	dir := setupUploadTest(t)
+	old := curConf()
+	t.Cleanup(func() { confPtr.Store(old) })
+	updateConfig(func(conf *Config) { conf.Quota = 100 })
+
+	fp := filepath.Join(dir, "a.bin")
+	if err := os.WriteFile(fp, make([]byte, 80), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mgr := storageMgr
+	mgr.used, mgr.scanned = 80, time.Now()
+	if _, ok := mgr.Reserve(30); ok {
+		t.Fatal("reserved beyond quota")
+	}
+
+	// 文件删除后，距上次统计超过间隔时重新统计
+	os.Remove(fp)
+	if _, ok := mgr.Reserve(30); ok {
+		t.Fatal("rescanned within interval")
+	}
+	mgr.scanned = time.Now().Add(-quotaRescanInterval - time.Second)
+	res, ok := mgr.Reserve(30)
+	if !ok || mgr.used != 0 || mgr.reserved != 30 {
+		t.Fatalf("after rescan: ok %t, used %d, reserved %d", ok, mgr.used, mgr.reserved)
+	}
+	res.Release()
+	if mgr.reserved != 0 {
+		t.Errorf("reserved = %d after release", mgr.reserved)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"toolkit/utils"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+const defaultThumbSize = 256
+const maxThumbSize = 1024
+
+// 超过该像素数的图片不生成缩略图，避免解码占用过多内存
+const maxThumbPixels = 50_000_000
+
+// 缩略图缓存超过该时长未被访问则清理
+const thumbCacheTTL = 30 * 24 * time.Hour
+
+var errThumbTooLarge = errors.New("图片尺寸过大")
+
+var thumbExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
+	".bmp": true, ".webp": true, ".tif": true, ".tiff": true,
+}
+
+// 限制同时生成缩略图的数量
+var thumbSem = make(chan struct{}, 2)
+
+var thumbDir string
+var thumbDirOnce sync.Once
+
+// 图片缩略图，参数 s 为最长边像素（默认 256，最大 1024）
+// PNG/GIF 可能带透明通道，输出 PNG，其他格式输出 JPEG
+func thumb(c *utils.Ctx) {
+	fileName, err := url.PathUnescape(strings.TrimPrefix(c.R.URL.Path, "/thumb/"))
+	if err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", nil, fileName)
+		return
+	}
+	fp, rel, err := resolvePath(fileName)
+	if err != nil || rel == "" {
+		writeErrorRsp(c, http.StatusBadRequest, "非法文件路径", err, fileName)
+		return
+	}
+	info, err := os.Stat(fp)
+	if err != nil || !info.Mode().IsRegular() || utils.IsIgnoreFile(info) {
+		writeErrorRsp(c, http.StatusNotFound, "文件不存在", err, rel)
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(rel))
+	if !thumbExts[ext] {
+		writeErrorRsp(c, http.StatusUnsupportedMediaType, "不支持预览的文件类型", nil, rel)
+		return
+	}
+
+	size := defaultThumbSize
+	if v := c.R.URL.Query().Get("s"); v != "" {
+		size, err = strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			writeErrorRsp(c, http.StatusBadRequest, "参数错误", err, "s", v)
+			return
+		}
+		size = min(size, maxThumbSize)
+	}
+
+	format := "jpeg"
+	if ext == ".png" || ext == ".gif" {
+		format = "png"
+	}
+	etag := fmt.Sprintf("\"%x-%x-%x\"", info.ModTime().UnixNano(), info.Size(), size)
+
+	cacheFile := thumbCachePath(fp, etag, format)
+	data, err := os.ReadFile(cacheFile)
+	if err == nil {
+		var now = time.Now()
+		os.Chtimes(cacheFile, now, now)
+	} else {
+		dlTracker.Start(rel)
+		data, err = makeThumb(fp, size, format)
+		dlTracker.End(rel)
+		if err != nil {
+			writeErrorRsp(c, http.StatusUnprocessableEntity, "生成缩略图失败", err, rel)
+			return
+		}
+		saveThumb(cacheFile, data)
+	}
+
+	c.W.Header().Set("Content-Type", "image/"+format)
+	c.W.Header().Set("Cache-Control", "private, max-age=86400")
+	c.W.Header().Set("ETag", etag)
+	http.ServeContent(c.W, c.R, "", info.ModTime(), bytes.NewReader(data))
+}
+
+func makeThumb(fp string, size int, format string) ([]byte, error) {
+	thumbSem <- struct{}{}
+	defer func() { <-thumbSem }()
+
+	file, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	conf, _, err := image.DecodeConfig(bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	if int64(conf.Width)*int64(conf.Height) > maxThumbPixels {
+		return nil, errThumbTooLarge
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if format == "jpeg" {
+		// JPEG 不支持透明，先铺白色背景
+		draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	}
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buf, dst)
+	} else {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80})
+	}
+	return buf.Bytes(), err
+}
+
+// 缓存文件名由文件路径、修改时间、大小及缩略图尺寸决定，文件变化后自然失效
+func thumbCachePath(fp, etag, format string) string {
+	thumbDirOnce.Do(initThumbDir)
+	sum := sha256.Sum256([]byte(fp + "|" + etag))
+	key := hex.EncodeToString(sum[:16])
+	return filepath.Join(thumbDir, key[:2], key+"."+format)
+}
+
+func saveThumb(cacheFile string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+		log.Error("缓存缩略图失败", err)
+		return
+	}
+	tmp := cacheFile + tmpSuffix
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Error("缓存缩略图失败", err)
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, cacheFile); err != nil {
+		log.Error("缓存缩略图失败", err)
+		os.Remove(tmp)
+	}
+}
+
+// 缓存放在系统缓存目录下，启动后首次使用时清理长时间未访问的缓存
+func initThumbDir() {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	thumbDir = filepath.Join(dir, "gfss", "thumb")
+	go cleanThumbCache(thumbDir)
+}
+
+func cleanThumbCache(dir string) {
+	var expire = time.Now().Add(-thumbCacheTTL)
+	filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().Before(expire) {
+			os.Remove(fp)
+		}
+		return nil
+	})
+}
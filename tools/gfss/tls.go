@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"toolkit/utils"
+)
+
+const selfSignedValidity = 2 * 365 * 24 * time.Hour
+
+// 自动生成的自签名证书及私钥保存在程序目录
+func selfSignedFiles() (certFile, keyFile string) {
+	dir := filepath.Dir(execPath)
+	return filepath.Join(dir, "gfss.crt"), filepath.Join(dir, "gfss.key")
+}
+
+// 加载证书，未指定证书文件时使用程序目录下自动生成的自签名证书
+// 返回 TLS 配置及证书的 SHA-256 指纹
+func loadTLSConfig(certFile, keyFile, host string) (*tls.Config, string, error) {
+	if certFile == "" || keyFile == "" {
+		certFile, keyFile = selfSignedFiles()
+		if err := ensureSelfSigned(certFile, keyFile, host); err != nil {
+			return nil, "", err
+		}
+	} else {
+		certFile = utils.NormalizePath(certFile)
+		keyFile = utils.NormalizePath(keyFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, "", err
+	}
+	conf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	return conf, certFingerprint(cert.Certificate[0]), nil
+}
+
+// 已有证书未过期且包含当前 IP 时直接复用，否则重新生成
+func ensureSelfSigned(certFile, keyFile, host string) error {
+	if b, err := os.ReadFile(certFile); err == nil {
+		if block, _ := pem.Decode(b); block != nil {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err == nil && time.Now().Add(24*time.Hour).Before(cert.NotAfter) &&
+				cert.VerifyHostname(host) == nil {
+				if _, err = os.Stat(keyFile); err == nil {
+					return nil
+				}
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostName, Organization: []string{"gfss"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostName != "" {
+		tmpl.DNSNames = append(tmpl.DNSNames, hostName)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+	} else if !slices.Contains(tmpl.DNSNames, host) {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return err
+	}
+	log.Infof("已生成自签名证书：%s", certFile)
+	return nil
+}
+
+// 证书指纹，格式同浏览器显示的 SHA-256 指纹
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// 将 HTTP 请求重定向到 HTTPS 端口
+func newRedirectServer(redirectPort int64) *http.Server {
+	return &http.Server{
+		Addr: fmt.Sprintf(":%d", redirectPort),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+			host = strings.Trim(host, "[]")
+			target := "https://" + net.JoinHostPort(host, strconv.FormatInt(port, 10)) + r.URL.RequestURI()
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       10 * time.Second,
+	}
+}
@@ -0,0 +1,718 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"toolkit/utils"
+)
+
+// 断点续传会话在最后一次活动后保留的时长
+const uploadSessionTTL = 24 * time.Hour
+
+var errUploadNotFound = errors.New("upload session not found")
+var errUploadBusy = errors.New("upload session busy")
+
+var uploadBufPool = sync.Pool{
+	New: func() any {
+		return make([]byte, 1*1024*1024)
+	},
+}
+
+func upload(c *utils.Ctx) {
+	var now = time.Now()
+	maxFileSize := int64(curConf().MaxFileSize)
+	body, done := bwMgr.Reader(c, c.R.Body)
+	defer done()
+	c.R.Body = body
+	// 使用流式 multipart 解析，避免将整个文件缓存在内存
+	mr, err := c.R.MultipartReader()
+	if err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "无效表单", err)
+		return
+	}
+
+	dir, ok := resolveUploadDir(c)
+	if !ok {
+		return
+	}
+	// 请求体大小已知时先整体预留，分块传输时边读边预留
+	res, ok := storageMgr.Reserve(c.R.ContentLength)
+	if !ok {
+		writeErrorRsp(c, http.StatusInsufficientStorage, "超出存储配额", nil, utils.FormatBytesIEC(int64(curConf().Quota)))
+		audit(c, "upload", relPath(dir), c.R.ContentLength, 0, http.StatusInsufficientStorage, "")
+		return
+	}
+	defer res.Release()
+
+	// 预期的 SHA-256 来自请求头，或文件字段之前的 sha256 表单字段，只作用于其后的一个文件
+	expect, ok := parseSHA256(c.R.Header.Get("Upload-SHA256"))
+	if !ok {
+		writeErrorRsp(c, http.StatusBadRequest, "无效校验值", nil, c.R.Header.Get("Upload-SHA256"))
+		return
+	}
+
+	var finalName string
+	var total int64
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeErrorRsp(c, http.StatusInternalServerError, "读取文件错误", err)
+			return
+		}
+
+		if part.FormName() == "sha256" {
+			v, _ := io.ReadAll(io.LimitReader(part, 128))
+			part.Close()
+			if expect, ok = parseSHA256(string(v)); !ok {
+				writeErrorRsp(c, http.StatusBadRequest, "无效校验值", nil, string(v))
+				return
+			}
+			continue
+		}
+
+		// 只处理名为 "file" 的文件字段
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		fname := filepath.Base(part.FileName())
+		if !isValidUploadName(fname) {
+			part.Close()
+			writeErrorRsp(c, http.StatusBadRequest, "没有文件名", nil)
+			return
+		}
+
+		start := time.Now()
+		s, out, err := tfTracker.Create(dir, fname, -1)
+		if err != nil {
+			part.Close()
+			writeErrorRsp(c, http.StatusInternalServerError, "创建临时文件失败", err, fname)
+			return
+		}
+		fnameTmp := filepath.Base(s.path)
+
+		defer tfTracker.Remove(s.ID)
+
+		// 写入的同时计算摘要
+		h := sha256.New()
+		buf := uploadBufPool.Get().([]byte)
+		n, err := io.CopyBuffer(io.MultiWriter(out, h), io.LimitReader(res.Reader(metricsMgr.Reader(part)), maxFileSize+1), buf)
+		uploadBufPool.Put(buf)
+
+		out.Close()
+		part.Close()
+
+		if n > maxFileSize {
+			writeErrorRsp(c, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("文件超出%s限制", utils.FormatBytesIEC(maxFileSize)), nil, fname)
+			audit(c, "upload", relPath(filepath.Join(dir, fname)), n, time.Since(start), http.StatusRequestEntityTooLarge, "")
+			return
+		}
+
+		if errors.Is(err, errQuotaExceeded) {
+			writeErrorRsp(c, http.StatusInsufficientStorage, "超出存储配额", nil, utils.FormatBytesIEC(int64(curConf().Quota)))
+			audit(c, "upload", relPath(filepath.Join(dir, fname)), n, time.Since(start), http.StatusInsufficientStorage, "")
+			return
+		}
+		if err != nil {
+			writeErrorRsp(c, http.StatusInternalServerError, "保存文件失败", err, fnameTmp)
+			return
+		}
+
+		sum := hex.EncodeToString(h.Sum(nil))
+		if expect != "" && sum != expect {
+			writeErrorRsp(c, http.StatusUnprocessableEntity, "文件校验失败", nil, fname, sum)
+			audit(c, "upload", relPath(filepath.Join(dir, fname)), n, time.Since(start), http.StatusUnprocessableEntity, sum)
+			return
+		}
+		expect = ""
+
+		if dupPath, mode := dedupUpload(c, dir, fname, sum, n); mode != dedupOff {
+			// 没有保存新的数据
+			res.Discard(n)
+			c.W.Header().Set("Upload-Dedup", mode)
+			finalName = recordUpload(c, dupPath, sum)
+			audit(c, "upload", finalName, n, time.Since(start), http.StatusOK, sum)
+			total += n
+			continue
+		}
+
+		finalPath, err := reserveFileName(dir, fname)
+		if err != nil {
+			writeErrorRsp(c, http.StatusInternalServerError, "检查目标文件冲突失败", err, fname)
+			return
+		}
+
+		if err = os.Rename(s.path, finalPath); err != nil {
+			os.Remove(finalPath)
+			writeErrorRsp(c, http.StatusInternalServerError, "重命名文件失败", err, fnameTmp)
+			return
+		}
+
+		res.Commit(n)
+		audit(c, "upload", recordUpload(c, finalPath, sum), n, time.Since(start), http.StatusOK, sum)
+		total += n
+		finalName = filepath.Base(finalPath)
+	}
+
+	c.W.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if total == 0 {
+		writeErrorRsp(c, http.StatusBadRequest, "没有检测到文件上传", nil)
+		return
+	}
+
+	logTransfer(c, finalName, total, time.Since(now))
+}
+
+type UploadRsp struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+// 创建断点续传会话
+// 请求头 Upload-Length 为文件总大小，Upload-Name 为 URL 编码的文件名，查询参数 path 为目标文件夹
+func createUpload(c *utils.Ctx) {
+	size, err := strconv.ParseInt(c.R.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		writeErrorRsp(c, http.StatusBadRequest, "无效文件大小", err)
+		return
+	}
+	if size == 0 {
+		writeErrorRsp(c, http.StatusBadRequest, "没有检测到文件上传", nil)
+		return
+	}
+	if maxFileSize := int64(curConf().MaxFileSize); size > maxFileSize {
+		writeErrorRsp(c, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("文件超出%s限制", utils.FormatBytesIEC(maxFileSize)), nil)
+		return
+	}
+
+	fname, err := url.PathUnescape(c.R.Header.Get("Upload-Name"))
+	fname = filepath.Base(fname)
+	if err != nil || !isValidUploadName(fname) {
+		writeErrorRsp(c, http.StatusBadRequest, "没有文件名", err)
+		return
+	}
+
+	expect, ok := parseSHA256(c.R.Header.Get("Upload-SHA256"))
+	if !ok {
+		writeErrorRsp(c, http.StatusBadRequest, "无效校验值", nil, c.R.Header.Get("Upload-SHA256"))
+		return
+	}
+
+	dir, ok := resolveUploadDir(c)
+	if !ok {
+		return
+	}
+	// 按文件大小预留空间，会话完成或删除时释放
+	res, ok := storageMgr.Reserve(size)
+	if !ok {
+		writeErrorRsp(c, http.StatusInsufficientStorage, "超出存储配额", nil, utils.FormatBytesIEC(int64(curConf().Quota)))
+		audit(c, "upload", relPath(filepath.Join(dir, fname)), size, 0, http.StatusInsufficientStorage, "")
+		return
+	}
+
+	s, out, err := tfTracker.Create(dir, fname, size)
+	if err != nil {
+		res.Release()
+		writeErrorRsp(c, http.StatusInternalServerError, "创建临时文件失败", err, fname)
+		return
+	}
+	out.Close()
+	s.SHA256 = expect
+	s.quota = res
+	tfTracker.Release(s)
+
+	c.Info("c", fname, utils.FormatBytesIEC(size), s.ID)
+	c.W.Header().Set("Location", "/upload/"+s.ID)
+	c.W.Header().Set("Upload-Offset", "0")
+	c.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.W.WriteHeader(http.StatusCreated)
+	json.NewEncoder(c.W).Encode(UploadRsp{ID: s.ID})
+}
+
+// 查询断点续传会话已提交的偏移量
+func headUpload(c *utils.Ctx) {
+	id := strings.TrimPrefix(c.R.URL.Path, "/upload/")
+	s, ok := tfTracker.Get(id)
+	if !ok {
+		c.W.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.W.Header().Set("Cache-Control", "no-store")
+	c.W.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+	c.W.Header().Set("Upload-Length", strconv.FormatInt(s.Size, 10))
+	c.W.WriteHeader(http.StatusOK)
+}
+
+// 从 Upload-Offset 处续传数据块，全部写完后移入工作目录
+func patchUpload(c *utils.Ctx) {
+	var now = time.Now()
+	id := strings.TrimPrefix(c.R.URL.Path, "/upload/")
+	offset, err := strconv.ParseInt(c.R.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		writeErrorRsp(c, http.StatusBadRequest, "无效偏移量", err, id)
+		return
+	}
+
+	s, err := tfTracker.Acquire(id)
+	if err != nil {
+		if errors.Is(err, errUploadBusy) {
+			writeErrorRsp(c, http.StatusConflict, "上传会话正在使用", nil, id)
+		} else {
+			writeErrorRsp(c, http.StatusNotFound, "上传会话不存在", nil, id)
+		}
+		return
+	}
+	defer tfTracker.Release(s)
+
+	if offset != s.Offset {
+		c.W.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+		writeErrorRsp(c, http.StatusConflict, "偏移量不匹配", nil, id)
+		return
+	}
+
+	if s.Offset < s.Size {
+		out, err := os.OpenFile(s.path, os.O_WRONLY, 0)
+		if err != nil {
+			writeErrorRsp(c, http.StatusInternalServerError, "打开临时文件失败", err, id)
+			return
+		}
+
+		var n int64
+		remain := s.Size - s.Offset
+		_, err = out.Seek(s.Offset, io.SeekStart)
+		if err == nil {
+			body, done := bwMgr.Reader(c, c.R.Body)
+			buf := uploadBufPool.Get().([]byte)
+			n, err = io.CopyBuffer(out, io.LimitReader(metricsMgr.Reader(body), remain+1), buf)
+			done()
+			uploadBufPool.Put(buf)
+		}
+
+		if n > remain {
+			out.Truncate(s.Offset)
+			out.Close()
+			c.W.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+			writeErrorRsp(c, http.StatusRequestEntityTooLarge, "数据超出文件大小", nil, id)
+			return
+		}
+
+		// 无法确认数据已落盘时丢弃本次数据块
+		if cerr := out.Close(); cerr != nil {
+			n, err = 0, cerr
+		}
+		// 即使传输中断，已写入的部分仍然有效，下次从新的偏移量继续
+		tfTracker.Advance(s, n)
+		c.W.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+		if err != nil {
+			writeErrorRsp(c, http.StatusInternalServerError, "保存文件失败", err, id)
+			return
+		}
+	}
+
+	if s.Offset < s.Size {
+		c.W.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// 分块可能来自多次请求，全部写完后重新读取临时文件计算摘要
+	sum, err := sumFile(s.path)
+	if err != nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "计算摘要失败", err, id)
+		return
+	}
+	if s.SHA256 != "" && sum != s.SHA256 {
+		tfTracker.Remove(s.ID)
+		writeErrorRsp(c, http.StatusUnprocessableEntity, "文件校验失败", nil, s.Name, sum)
+		audit(c, "upload", relPath(filepath.Join(s.dir, s.Name)), s.Size, now.Sub(s.CreateAt), http.StatusUnprocessableEntity, sum)
+		return
+	}
+
+	c.W.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if dupPath, mode := dedupUpload(c, s.dir, s.Name, sum, s.Size); mode != dedupOff {
+		tfTracker.Remove(s.ID)
+		c.W.Header().Set("Upload-Dedup", mode)
+		rel := recordUpload(c, dupPath, sum)
+		audit(c, "upload", rel, s.Size, now.Sub(s.CreateAt), http.StatusOK, sum)
+		logTransfer(c, rel, s.Size, now.Sub(s.CreateAt))
+		// skip 模式返回已有文件相对工作目录的路径
+		if mode == dedupLink {
+			rel = filepath.Base(dupPath)
+		}
+		c.W.Write([]byte(rel))
+		return
+	}
+
+	finalPath, err := reserveFileName(s.dir, s.Name)
+	if err != nil {
+		writeErrorRsp(c, http.StatusInternalServerError, "检查目标文件冲突失败", err, s.Name)
+		return
+	}
+
+	if err = os.Rename(s.path, finalPath); err != nil {
+		os.Remove(finalPath)
+		writeErrorRsp(c, http.StatusInternalServerError, "重命名文件失败", err, id)
+		return
+	}
+	s.quota.Commit(s.Size)
+	tfTracker.Remove(s.ID)
+	audit(c, "upload", recordUpload(c, finalPath, sum), s.Size, now.Sub(s.CreateAt), http.StatusOK, sum)
+
+	finalName := filepath.Base(finalPath)
+	logTransfer(c, finalName, s.Size, now.Sub(s.CreateAt))
+	c.W.Write([]byte(finalName))
+}
+
+// 新保存的文件记录到摘要缓存，开启去重时加入去重索引
+func indexFile(fp, sum string) {
+	if info, err := os.Stat(fp); err == nil {
+		hashCache.Put(fp, info, sum)
+		if curConf().Dedup != dedupOff {
+			dedupIdx.Add(fp, info, sum)
+		}
+	}
+}
+
+// 记录上传完成的文件摘要，通过响应头返回摘要及文件相对工作目录的路径
+func recordUpload(c *utils.Ctx, finalPath, sum string) string {
+	indexFile(finalPath, sum)
+	rel, err := filepath.Rel(workDir, finalPath)
+	if err != nil {
+		rel = filepath.Base(finalPath)
+	}
+	rel = filepath.ToSlash(rel)
+	c.W.Header().Set("Upload-SHA256", sum)
+	c.W.Header().Set("Upload-Path", url.PathEscape(rel))
+	return rel
+}
+
+func isValidUploadName(fname string) bool {
+	return fname != "" && fname != "." && fname != ".." &&
+		fname != string(filepath.Separator) && !strings.HasSuffix(fname, tmpSuffix)
+}
+
+// 在目录中占用一个不冲突的文件名，冲突时依次尝试 name(1).ext、name(2).ext…
+func reserveFileName(dir, fname string) (string, error) {
+	return reserveName(dir, fname, func(fp string) error {
+		f, err := os.OpenFile(fp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+		if err == nil {
+			f.Close()
+		}
+		return err
+	})
+}
+
+// 按 name(n).ext 规则依次尝试，create 返回已存在错误时尝试下一个名称
+func reserveName(dir, fname string, create func(fp string) error) (string, error) {
+	baseName := strings.TrimSuffix(fname, filepath.Ext(fname))
+	ext := filepath.Ext(fname)
+	counter := 0
+
+	var finalPath string
+	for {
+		if counter == 0 {
+			finalPath = filepath.Join(dir, fname)
+		} else {
+			finalPath = filepath.Join(dir, fmt.Sprintf("%s(%d)%s", baseName, counter, ext))
+		}
+
+		err := create(finalPath)
+		if err == nil {
+			return finalPath, nil
+		}
+
+		// 说明是没有写入权限或其他严重错误，直接中断
+		if !os.IsExist(err) {
+			return "", err
+		}
+
+		counter++
+	}
+}
+
+func logTransfer(c *utils.Ctx, fileName string, total int64, elapsed time.Duration) {
+	speed := int64(0)
+	if elapsed > 0 {
+		speed = int64(float64(total) / elapsed.Seconds())
+	}
+	c.Log(1, "inf", fmt.Sprintf(
+		"%s %s %v %s/s",
+		fileName,
+		utils.FormatBytesIEC(total),
+		elapsed.Round(time.Millisecond),
+		utils.FormatBytesIEC(speed),
+	))
+}
+
+type UploadSession struct {
+	ID       string
+	Name     string
+	Size     int64
+	Offset   int64
+	CreateAt time.Time
+	SHA256   string
+
+	dir      string
+	path     string
+	expireAt time.Time
+	busy     bool
+	quota    *Reservation
+}
+
+// 关闭时保存的断点续传会话，重启后恢复
+type savedSession struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Offset   int64  `json:"offset"`
+	CreateAt int64  `json:"createAt"` // 毫秒时间戳
+	SHA256   string `json:"sha256,omitempty"`
+	Dir      string `json:"dir"`
+}
+
+type TmpFileTracker struct {
+	mux      sync.Mutex
+	file     string
+	sessions map[string]*UploadSession
+}
+
+func NewTmpFileTracker(file string) *TmpFileTracker {
+	t := &TmpFileTracker{
+		file:     file,
+		sessions: make(map[string]*UploadSession),
+	}
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			t.Expire()
+		}
+	}()
+	return t
+}
+
+// 创建上传会话及其临时文件，返回的会话处于占用状态，用完需 Release 或 Remove
+func (t *TmpFileTracker) Create(dir, name string, size int64) (*UploadSession, *os.File, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, nil, err
+	}
+	id := hex.EncodeToString(b[:])
+	fp := filepath.Join(dir, id+tmpSuffix)
+	out, err := os.OpenFile(fp, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	s := &UploadSession{
+		ID:       id,
+		Name:     name,
+		Size:     size,
+		CreateAt: now,
+		dir:      dir,
+		path:     fp,
+		expireAt: now.Add(uploadSessionTTL),
+		busy:     true,
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.sessions[id] = s
+	return s, out, nil
+}
+
+// 获取会话快照，不占用会话
+func (t *TmpFileTracker) Get(id string) (UploadSession, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s, ok := t.sessions[id]
+	if !ok {
+		return UploadSession{}, false
+	}
+	return *s, true
+}
+
+// 占用会话，同一会话同时只允许一个请求写入
+func (t *TmpFileTracker) Acquire(id string) (*UploadSession, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s, ok := t.sessions[id]
+	if !ok {
+		return nil, errUploadNotFound
+	}
+	if s.busy {
+		return nil, errUploadBusy
+	}
+	s.busy = true
+	return s, nil
+}
+
+// 更新已提交的偏移量，只能由占用会话的请求调用
+func (t *TmpFileTracker) Advance(s *UploadSession, n int64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s.Offset += n
+}
+
+func (t *TmpFileTracker) Release(s *UploadSession) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s.busy = false
+	s.expireAt = time.Now().Add(uploadSessionTTL)
+}
+
+func (t *TmpFileTracker) Remove(id string) {
+	t.mux.Lock()
+	s, ok := t.sessions[id]
+	delete(t.sessions, id)
+	t.mux.Unlock()
+	if ok {
+		os.Remove(s.path)
+		s.quota.Release()
+	}
+}
+
+// 判断文件夹内是否有未完成的上传，移动文件夹会导致其无法完成
+func (t *TmpFileTracker) IsUploading(dir string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for _, s := range t.sessions {
+		if isSubPath(dir, s.dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// 未完成上传的临时文件数量
+func (t *TmpFileTracker) Count() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return len(t.sessions)
+}
+
+// 清理超时未活动的会话
+func (t *TmpFileTracker) Expire() {
+	var now = time.Now()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for id, s := range t.sessions {
+		if s.busy || now.Before(s.expireAt) {
+			continue
+		}
+		delete(t.sessions, id)
+		os.Remove(s.path)
+		s.quota.Release()
+		log.Info("e", s.Name, id)
+	}
+}
+
+// 关闭时删除普通上传的临时文件，保存断点续传会话以便重启后继续
+func (t *TmpFileTracker) Clean() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	var list []savedSession
+	for id, s := range t.sessions {
+		delete(t.sessions, id)
+		if s.Size < 0 {
+			os.Remove(s.path)
+			continue
+		}
+		list = append(list, savedSession{
+			ID:       s.ID,
+			Name:     s.Name,
+			Size:     s.Size,
+			Offset:   s.Offset,
+			CreateAt: s.CreateAt.UnixMilli(),
+			SHA256:   s.SHA256,
+			Dir:      s.dir,
+		})
+	}
+	if len(list) == 0 {
+		os.Remove(t.file)
+		return
+	}
+	b, err := json.Marshal(list)
+	if err == nil {
+		err = os.WriteFile(t.file, b, 0600)
+	}
+	if err != nil {
+		log.Error("保存上传会话失败", err)
+	}
+}
+
+// 恢复上次关闭时保存的断点续传会话，需在设置工作目录后调用
+// 临时文件截断到已提交的偏移量，不在当前工作目录或超出配额的会话被丢弃
+func (t *TmpFileTracker) Restore() {
+	b, err := os.ReadFile(t.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("读取上传会话失败", err)
+		}
+		return
+	}
+	os.Remove(t.file)
+	var list []savedSession
+	if err = json.Unmarshal(b, &list); err != nil {
+		log.Error("读取上传会话失败", t.file, err)
+		return
+	}
+
+	now := time.Now()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for _, v := range list {
+		fp := filepath.Join(v.Dir, v.ID+tmpSuffix)
+		info, err := os.Stat(fp)
+		if err != nil {
+			continue
+		}
+		var res *Reservation
+		ok := isSubPath(workDir, v.Dir) && v.Offset >= 0 && v.Offset <= v.Size && info.Size() >= v.Offset
+		if ok {
+			res, ok = storageMgr.Reserve(v.Size)
+		}
+		if !ok || os.Truncate(fp, v.Offset) != nil {
+			res.Release()
+			os.Remove(fp)
+			continue
+		}
+		t.sessions[v.ID] = &UploadSession{
+			ID:       v.ID,
+			Name:     v.Name,
+			Size:     v.Size,
+			Offset:   v.Offset,
+			CreateAt: time.UnixMilli(v.CreateAt),
+			SHA256:   v.SHA256,
+			dir:      v.Dir,
+			path:     fp,
+			expireAt: now.Add(uploadSessionTTL),
+			quota:    res,
+		}
+	}
+	if len(t.sessions) > 0 {
+		log.Infof("已恢复 %d 个上传会话", len(t.sessions))
+	}
+}
+
+func uploadsFile() string {
+	return filepath.Join(filepath.Dir(execPath), "gfss_uploads.json")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"toolkit/utils"
+)
+
+// 在临时工作目录中运行上传处理函数
+func setupUploadTest(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	oldDir, oldTracker, oldBw, oldMetrics := workDir, tfTracker, bwMgr, metricsMgr
+	oldStorage, oldHash, oldAudit, oldDedup := storageMgr, hashCache, auditLog, dedupIdx
+	t.Cleanup(func() {
+		auditLog.Close()
+		workDir, tfTracker, bwMgr, metricsMgr = oldDir, oldTracker, oldBw, oldMetrics
+		storageMgr, hashCache, auditLog, dedupIdx = oldStorage, oldHash, oldAudit, oldDedup
+	})
+
+	workDir = dir
+	meta := t.TempDir()
+	tfTracker = NewTmpFileTracker(filepath.Join(meta, "uploads.json"))
+	bwMgr = NewBandwidthManager()
+	metricsMgr = NewMetrics()
+	storageMgr = NewStorageManager()
+	hashCache = NewHashCache()
+	auditLog = NewAuditLog(filepath.Join(meta, "audit.jsonl"))
+	dedupIdx = NewDedupIndex(filepath.Join(meta, "dedup.json"))
+	return dir
+}
+
+func callUpload(handler func(*utils.Ctx), method, target string, body io.Reader, header map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, target, body)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	handler(&utils.Ctx{W: w, R: r, ID: "127.0.0.1"})
+	return w
+}
+
+func TestPatchUploadOffset(t *testing.T) {
+	dir := setupUploadTest(t)
+
+	w := callUpload(createUpload, http.MethodPost, "/upload/new", nil,
+		map[string]string{"Upload-Length": "10", "Upload-Name": "a.txt"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	loc := w.Header().Get("Location")
+	id := strings.TrimPrefix(loc, "/upload/")
+	s, ok := tfTracker.Get(id)
+	if !ok {
+		t.Fatal("session not found")
+	}
+
+	steps := []struct {
+		name   string
+		offset string
+		body   io.Reader
+		status int
+		want   int64 // 之后的偏移量
+	}{
+		{"wrong offset", "3", strings.NewReader("45"), http.StatusConflict, 0},
+		{"invalid offset", "x", strings.NewReader("1"), http.StatusBadRequest, 0},
+		{"first chunk", "0", strings.NewReader("12345"), http.StatusNoContent, 5},
+		{"replayed chunk", "0", strings.NewReader("12345"), http.StatusConflict, 5},
+		{"too large", "5", strings.NewReader("67890abc"), http.StatusRequestEntityTooLarge, 5},
+		// 中断时已写入的部分保留
+		{"interrupted", "5", io.MultiReader(strings.NewReader("6"), iotest.ErrReader(io.ErrUnexpectedEOF)),
+			http.StatusInternalServerError, 6},
+		{"resumed", "6", strings.NewReader("78"), http.StatusNoContent, 8},
+	}
+	for _, st := range steps {
+		w = callUpload(patchUpload, http.MethodPatch, loc, st.body, map[string]string{"Upload-Offset": st.offset})
+		if w.Code != st.status {
+			t.Fatalf("%s: status %d, want %d (%s)", st.name, w.Code, st.status, w.Body)
+		}
+		got, _ := tfTracker.Get(id)
+		if got.Offset != st.want {
+			t.Fatalf("%s: offset %d, want %d", st.name, got.Offset, st.want)
+		}
+		if h := w.Header().Get("Upload-Offset"); st.status != http.StatusBadRequest && h != "" && h != strconv.FormatInt(st.want, 10) {
+			t.Errorf("%s: Upload-Offset %q, want %d", st.name, h, st.want)
+		}
+		if info, err := os.Stat(s.path); err != nil || info.Size() != st.want {
+			t.Errorf("%s: temp file size %v, want %d", st.name, info, st.want)
+		}
+	}
+
+	w = callUpload(headUpload, http.MethodHead, loc, nil, nil)
+	if w.Header().Get("Upload-Offset") != "8" || w.Header().Get("Upload-Length") != "10" {
+		t.Errorf("head: %v", w.Header())
+	}
+
+	w = callUpload(patchUpload, http.MethodPatch, loc, strings.NewReader("90"), map[string]string{"Upload-Offset": "8"})
+	if w.Code != http.StatusOK || w.Body.String() != "a.txt" {
+		t.Fatalf("last chunk: %d %s", w.Code, w.Body)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(b) != "1234567890" {
+		t.Errorf("content = %q, %v", b, err)
+	}
+	if w = callUpload(headUpload, http.MethodHead, loc, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("head after finish: %d", w.Code)
+	}
+	if _, err := os.Stat(s.path); !os.IsNotExist(err) {
+		t.Errorf("temp file left: %v", err)
+	}
+}
+
+func TestUploadSessionRestore(t *testing.T) {
+	dir := setupUploadTest(t)
+
+	create := func(size, offset, fileSize int64) *UploadSession {
+		s, out, err := tfTracker.Create(dir, "f.bin", size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Truncate(fileSize)
+		out.Close()
+		tfTracker.Advance(s, offset)
+		tfTracker.Release(s)
+		return s
+	}
+	// 已提交部分之后的数据可能未完整写入，恢复时截断
+	kept := create(10, 4, 6)
+	// 临时文件比已提交的偏移量短，无法续传
+	short := create(10, 8, 3)
+
+	tfTracker.Clean()
+	if _, err := os.Stat(tfTracker.file); err != nil {
+		t.Fatal(err)
+	}
+	tfTracker = NewTmpFileTracker(tfTracker.file)
+	tfTracker.Restore()
+
+	s, ok := tfTracker.Get(kept.ID)
+	if !ok || s.Offset != 4 || s.Size != 10 {
+		t.Fatalf("restored = %+v, %t", s, ok)
+	}
+	if info, err := os.Stat(kept.path); err != nil || info.Size() != 4 {
+		t.Errorf("temp file size %v, want 4", info)
+	}
+	if _, ok = tfTracker.Get(short.ID); ok {
+		t.Error("short session restored")
+	}
+	if _, err := os.Stat(short.path); !os.IsNotExist(err) {
+		t.Errorf("short temp file left: %v", err)
+	}
+}
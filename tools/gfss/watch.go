@@ -0,0 +1,322 @@
+package main
+
+import (
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"toolkit/utils"
+)
+
+// 无系统通知时的轮询间隔
+const watchInterval = 3 * time.Second
+
+// 有系统通知时的兜底扫描间隔，同步通知遗漏的变化（如 inotify 队列溢出）
+const watchRescanInterval = time.Minute
+
+// 连续变化时等待安静的时长，以及最长等待时长
+const watchDebounce = 500 * time.Millisecond
+const watchMaxDelay = 5 * time.Second
+
+// 扫描的文件数量上限，避免工作目录过大时占用过多资源
+const maxWatchEntries = 20000
+
+type fileStamp struct {
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+type RenameEvent struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// 监控系统变化通知，不同平台分别实现
+type changeNotifier interface {
+	C() <-chan struct{}
+	// 按需添加需要监控的子文件夹
+	Watch(dirs []string)
+	Close()
+}
+
+// 监控工作目录的变化，通过 SSE 推送 added/removed/renamed 事件
+type DirWatcher struct {
+	// 扫描及接口修改文件期间持有，避免同一变化被重复推送
+	mux      sync.Mutex
+	root     string
+	snap     map[string]fileStamp
+	notifier changeNotifier
+	kick     chan struct{}
+}
+
+func NewDirWatcher() *DirWatcher {
+	return &DirWatcher{
+		kick: make(chan struct{}, 1),
+	}
+}
+
+func (w *DirWatcher) Run() {
+	w.reset()
+	timer := time.NewTimer(w.interval())
+	defer timer.Stop()
+
+	for {
+		var notify <-chan struct{}
+		if w.notifier != nil {
+			notify = w.notifier.C()
+		}
+		select {
+		case <-timer.C:
+		case <-w.kick:
+		case <-notify:
+			w.debounce(notify)
+		}
+
+		w.mux.Lock()
+		// 切换了工作目录，重新建立快照
+		if w.root != workDir {
+			w.reset()
+		} else {
+			w.check()
+		}
+		w.mux.Unlock()
+		timer.Reset(w.interval())
+	}
+}
+
+// 有系统通知时只需低频扫描兜底
+func (w *DirWatcher) interval() time.Duration {
+	if w.notifier != nil {
+		return watchRescanInterval
+	}
+	return watchInterval
+}
+
+// 立即扫描一次，如切换工作目录后
+func (w *DirWatcher) Kick() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (w *DirWatcher) Lock() {
+	w.mux.Lock()
+}
+
+func (w *DirWatcher) Unlock() {
+	w.mux.Unlock()
+}
+
+// 接口重命名或移动文件后同步快照并推送，需在 Lock 期间调用
+func (w *DirWatcher) Renamed(from, to string) {
+	if w.root == workDir && w.snap != nil {
+		for rel, stamp := range maps.Clone(w.snap) {
+			if rel == from || strings.HasPrefix(rel, from+"/") {
+				delete(w.snap, rel)
+				w.snap[to+strings.TrimPrefix(rel, from)] = stamp
+			}
+		}
+		if w.notifier != nil {
+			w.notifier.Watch(snapDirs(w.root, w.snap))
+		}
+	}
+	dedupIdx.Rename(from, to)
+	shareMgr.Rename(from, to)
+	sseMgr.Broadcast("renamed", []RenameEvent{{From: from, To: to}})
+}
+
+// 接口新建文件或文件夹后同步快照并推送，需在 Lock 期间调用
+func (w *DirWatcher) Added(rel string) {
+	if w.root == workDir && w.snap != nil {
+		fp := filepath.Join(w.root, filepath.FromSlash(rel))
+		if info, err := os.Stat(fp); err == nil {
+			stamp := fileStamp{isDir: info.IsDir(), modTime: info.ModTime()}
+			if !stamp.isDir {
+				stamp.size = info.Size()
+			}
+			w.snap[rel] = stamp
+			if stamp.isDir {
+				for sub, stamp := range scanTree(fp) {
+					w.snap[rel+"/"+sub] = stamp
+				}
+				if w.notifier != nil {
+					w.notifier.Watch(snapDirs(w.root, w.snap))
+				}
+			}
+		}
+	}
+	sseMgr.Broadcast("added", []string{rel})
+}
+
+// 等待变化平息后再扫描，合并连续的变化
+func (w *DirWatcher) debounce(notify <-chan struct{}) {
+	deadline := time.Now().Add(watchMaxDelay)
+	timer := time.NewTimer(watchDebounce)
+	defer timer.Stop()
+	for {
+		select {
+		case <-notify:
+			if time.Now().After(deadline) {
+				return
+			}
+			timer.Reset(watchDebounce)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+func (w *DirWatcher) reset() {
+	if w.notifier != nil {
+		w.notifier.Close()
+		w.notifier = nil
+	}
+	w.root = workDir
+	w.snap = scanTree(w.root)
+
+	notifier, err := newChangeNotifier(w.root)
+	if err != nil {
+		log.Info("目录监控使用轮询方式", err)
+		return
+	}
+	w.notifier = notifier
+	w.notifier.Watch(snapDirs(w.root, w.snap))
+}
+
+func (w *DirWatcher) check() {
+	snap := scanTree(w.root)
+	added, removed, renamed := diffSnap(w.snap, snap)
+	w.snap = snap
+	if len(added) == 0 && len(removed) == 0 && len(renamed) == 0 {
+		return
+	}
+
+	if w.notifier != nil {
+		w.notifier.Watch(snapDirs(w.root, snap))
+	}
+	// 同步在其他程序中删除或重命名的文件
+	for _, rel := range removed {
+		dedupIdx.Remove(rel)
+	}
+	for _, r := range renamed {
+		dedupIdx.Rename(r.From, r.To)
+	}
+
+	if len(added) > 0 {
+		sseMgr.Broadcast("added", added)
+	}
+	if len(removed) > 0 {
+		sseMgr.Broadcast("removed", removed)
+	}
+	if len(renamed) > 0 {
+		sseMgr.Broadcast("renamed", renamed)
+	}
+}
+
+// 递归扫描工作目录，过滤规则与文件列表一致
+func scanTree(root string) map[string]fileStamp {
+	snap := make(map[string]fileStamp)
+	filepath.WalkDir(root, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || fp == root {
+			return nil
+		}
+		if len(snap) >= maxWatchEntries {
+			return fs.SkipAll
+		}
+		if isSidecarFile(fp) || strings.HasSuffix(d.Name(), tmpSuffix) ||
+			(!d.IsDir() && !d.Type().IsRegular()) {
+			// 复制中的临时文件夹
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if utils.IsIgnoreFile(info) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, fp)
+		if err != nil {
+			return nil
+		}
+		stamp := fileStamp{isDir: d.IsDir(), modTime: info.ModTime()}
+		if !stamp.isDir {
+			stamp.size = info.Size()
+		}
+		snap[filepath.ToSlash(rel)] = stamp
+		return nil
+	})
+	return snap
+}
+
+func snapDirs(root string, snap map[string]fileStamp) []string {
+	dirs := []string{root}
+	for rel, stamp := range snap {
+		if stamp.isDir {
+			dirs = append(dirs, filepath.Join(root, filepath.FromSlash(rel)))
+		}
+	}
+	return dirs
+}
+
+// 对比两次快照，大小和修改时间相同的一增一删视为重命名
+func diffSnap(old, cur map[string]fileStamp) (added, removed []string, renamed []RenameEvent) {
+	for rel := range cur {
+		if _, ok := old[rel]; !ok {
+			added = append(added, rel)
+		}
+	}
+	for rel := range old {
+		if _, ok := cur[rel]; !ok {
+			removed = append(removed, rel)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	for i := 0; i < len(removed); i++ {
+		from := old[removed[i]]
+		for j := 0; j < len(added); j++ {
+			to := cur[added[j]]
+			if to.isDir != from.isDir || to.size != from.size || !to.modTime.Equal(from.modTime) {
+				continue
+			}
+			renamed = append(renamed, RenameEvent{From: removed[i], To: added[j]})
+			removed = append(removed[:i], removed[i+1:]...)
+			added = append(added[:j], added[j+1:]...)
+			i--
+			break
+		}
+	}
+
+	// 文件夹重命名时只报告文件夹本身，忽略其中的文件
+	for _, r := range slices.Clone(renamed) {
+		if !old[r.From].isDir {
+			continue
+		}
+		removed = slices.DeleteFunc(removed, func(rel string) bool {
+			return strings.HasPrefix(rel, r.From+"/")
+		})
+		added = slices.DeleteFunc(added, func(rel string) bool {
+			return strings.HasPrefix(rel, r.To+"/")
+		})
+		renamed = slices.DeleteFunc(renamed, func(e RenameEvent) bool {
+			return strings.HasPrefix(e.From, r.From+"/")
+		})
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// 使用 inotify 监控，每个文件夹需要单独添加
+type inotifyNotifier struct {
+	fd int
+	f  *os.File
+	ch chan struct{}
+}
+
+func newChangeNotifier(root string) (changeNotifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// 非阻塞描述符交给 os.File 后由运行时轮询，Close 时读取会立即返回
+	n := &inotifyNotifier{fd: fd, f: os.NewFile(uintptr(fd), "inotify"), ch: make(chan struct{}, 1)}
+	go n.loop()
+	return n, nil
+}
+
+func (n *inotifyNotifier) loop() {
+	buf := make([]byte, 64*1024)
+	for {
+		if _, err := n.f.Read(buf); err != nil {
+			return
+		}
+		select {
+		case n.ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *inotifyNotifier) C() <-chan struct{} {
+	return n.ch
+}
+
+// 重复添加同一文件夹会复用原有监控，已删除的文件夹由内核自动移除
+func (n *inotifyNotifier) Watch(dirs []string) {
+	const mask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM |
+		unix.IN_MOVED_TO | unix.IN_ONLYDIR
+	for _, dir := range dirs {
+		unix.InotifyAddWatch(n.fd, dir, mask)
+	}
+}
+
+func (n *inotifyNotifier) Close() {
+	n.f.Close()
+}
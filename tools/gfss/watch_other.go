@@ -0,0 +1,9 @@
+//go:build !windows && !linux
+
+package main
+
+import "errors"
+
+func newChangeNotifier(root string) (changeNotifier, error) {
+	return nil, errors.ErrUnsupported
+}
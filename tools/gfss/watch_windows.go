@@ -0,0 +1,62 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// 使用 FindFirstChangeNotification 监控整个目录树
+type winNotifier struct {
+	h    windows.Handle
+	stop windows.Handle // Close 时触发，唤醒等待中的 loop
+	done chan struct{}
+	ch   chan struct{}
+}
+
+func newChangeNotifier(root string) (changeNotifier, error) {
+	// 只关心增删及重命名，忽略写入带来的变化
+	const filter = windows.FILE_NOTIFY_CHANGE_FILE_NAME |
+		windows.FILE_NOTIFY_CHANGE_DIR_NAME
+	stop, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.FindFirstChangeNotification(root, true, filter)
+	if err != nil {
+		windows.CloseHandle(stop)
+		return nil, err
+	}
+	n := &winNotifier{h: h, stop: stop, done: make(chan struct{}), ch: make(chan struct{}, 1)}
+	go n.loop()
+	return n, nil
+}
+
+// 通知句柄只在 loop 退出后关闭，避免等待期间句柄被关闭
+func (n *winNotifier) loop() {
+	defer close(n.done)
+	defer windows.FindCloseChangeNotification(n.h)
+	handles := []windows.Handle{n.h, n.stop}
+	for {
+		ev, err := windows.WaitForMultipleObjects(handles, false, windows.INFINITE)
+		if err != nil || ev != windows.WAIT_OBJECT_0 {
+			return
+		}
+		select {
+		case n.ch <- struct{}{}:
+		default:
+		}
+		if windows.FindNextChangeNotification(n.h) != nil {
+			return
+		}
+	}
+}
+
+func (n *winNotifier) C() <-chan struct{} {
+	return n.ch
+}
+
+// 已监控整个目录树，无需单独添加子文件夹
+func (n *winNotifier) Watch(dirs []string) {}
+
+func (n *winNotifier) Close() {
+	windows.SetEvent(n.stop)
+	<-n.done
+	windows.CloseHandle(n.stop)
+}
@@ -13,6 +13,7 @@ import (
 	"syscall"
 	"time"
 	"toolkit/utils"
+	"toolkit/utils/qr"
 
 	"github.com/amalfra/etag/v3"
 	"github.com/go-vgo/robotgo"
@@ -79,11 +80,28 @@ func main() {
 		w.Write(icon)
 	})
 
+	link := fmt.Sprintf("http://%s:%d", ip, port)
+	code, err := qr.Encode([]byte(link), qr.M)
+	if err != nil {
+		fmt.Println("生成二维码失败：", err)
+		os.Exit(1)
+	}
+	qrData, err := code.PNG(8)
+	if err != nil {
+		fmt.Println("生成二维码失败：", err)
+		os.Exit(1)
+	}
+	mux.HandleFunc("/qr.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(qrData)
+	})
+
 	mux.HandleFunc("/ws", handleWS)
 
 	fmt.Println("----------web触控板----------")
 	fmt.Printf("鼠标灵敏度：%.2f，滚轮灵敏度：%.2f\n", moveScale, scrollScale)
-	fmt.Printf("网页链接：http://%s:%d %s\n", ip, port, ipMsg)
+	fmt.Printf("网页链接：%s %s\n", link, ipMsg)
+	fmt.Print(code.Terminal())
 
 	server := &http.Server{Addr: addr, Handler: mux}
 	go func() {
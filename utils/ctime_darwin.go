@@ -0,0 +1,15 @@
+package utils
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// 文件创建时间，获取失败时使用修改时间
+func CreateTime(fp string, info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Birthtimespec.Unix())
+	}
+	return info.ModTime()
+}
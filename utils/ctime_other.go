@@ -0,0 +1,13 @@
+//go:build !windows && !linux && !darwin
+
+package utils
+
+import (
+	"os"
+	"time"
+)
+
+// 当前平台不支持获取创建时间，使用修改时间
+func CreateTime(fp string, info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !linux && !darwin && !freebsd
+
+package utils
+
+import "errors"
+
+// 当前平台不支持获取可用空间
+func DiskFree(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,16 @@
+package utils
+
+import "golang.org/x/sys/windows"
+
+// 路径所在分区对当前用户可用的空间
+func DiskFree(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err = windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
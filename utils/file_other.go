@@ -0,0 +1,10 @@
+//go:build !windows
+
+package utils
+
+import "os"
+
+// 忽略符号链接，. 开头的文件与其他文件相同
+func IsIgnoreFile(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0
+}
@@ -0,0 +1,5 @@
+package utils
+
+// 编译时通过 -X toolkit/utils.GuiMode=1 指定为托盘程序
+var GuiMode string
+var IsGuiMode = GuiMode == "1"
@@ -0,0 +1,474 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const mdnsTTL = 120
+
+// 兼容单播查询的响应 TTL 不超过 10 秒
+const mdnsLegacyTTL = 10
+
+// 唯一记录的 cache-flush 标志，查询中同一位表示希望单播响应
+const mdnsClassFlag = 1 << 15
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const mdnsServices = "_services._dns-sd._udp.local."
+
+// DNS-SD 服务描述
+type MDNSService struct {
+	Instance string   // 实例名称，如 "文件共享 on PC"
+	Service  string   // 服务类型，如 "_http._tcp"
+	Host     string   // SRV 指向的主机名（不含 .local），为空时为 本机名称-mdns
+	Port     int      // 服务端口
+	Text     []string // TXT 记录，格式为 key=value
+}
+
+// 通过 mDNS 广播服务，响应局域网内的查询
+type MDNSServer struct {
+	svc      MDNSService
+	conn     *net.UDPConn
+	service  dnsmessage.Name
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	closeMux sync.Once
+}
+
+// 监听 5353 端口并发布服务，Close 时发送下线通知
+func AdvertiseMDNS(svc MDNSService) (*MDNSServer, error) {
+	s, err := newMDNSServer(svc)
+	if err != nil {
+		return nil, err
+	}
+	if s.conn, err = net.ListenMulticastUDP("udp4", nil, mdnsGroup); err != nil {
+		return nil, err
+	}
+	// 默认只加入一个网卡的组播组，逐个网卡加入以响应所有局域网
+	pc := ipv4.NewPacketConn(s.conn)
+	ifaces, _ := net.Interfaces()
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagUp != 0 && ifaces[i].Flags&net.FlagMulticast != 0 {
+			pc.JoinGroup(&ifaces[i], mdnsGroup)
+		}
+	}
+	pc.SetMulticastLoopback(true)
+
+	go s.serve()
+	go func() {
+		// 启动时主动通告两次
+		for i := 0; i < 2; i++ {
+			s.announce(mdnsTTL)
+			time.Sleep(time.Second)
+		}
+	}()
+	return s, nil
+}
+
+// 主机名不使用系统主机名：系统的 mDNS 服务（Avahi、Bonjour）已发布 <主机名>.local，
+// 这里不做探测，以相同名称发布不同地址的唯一记录会引起冲突
+func newMDNSServer(svc MDNSService) (*MDNSServer, error) {
+	s := &MDNSServer{svc: svc}
+	var err error
+	if s.service, err = dnsmessage.NewName(svc.Service + ".local."); err != nil {
+		return nil, err
+	}
+	if s.instance, err = dnsmessage.NewName(mdnsLabel(svc.Instance) + "." + svc.Service + ".local."); err != nil {
+		return nil, err
+	}
+	host := svc.Host
+	if host == "" {
+		name, _ := os.Hostname()
+		host = mdnsHostLabel(name) + "-mdns"
+	}
+	if s.host, err = dnsmessage.NewName(mdnsHostLabel(host) + ".local."); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *MDNSServer) Close() {
+	s.closeMux.Do(func() {
+		s.announce(0)
+		s.conn.Close()
+	})
+}
+
+func (s *MDNSServer) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		s.handle(buf[:n], src)
+	}
+}
+
+func (s *MDNSServer) handle(msg []byte, src *net.UDPAddr) {
+	b, unicast := s.respond(msg, src.Port != mdnsGroup.Port)
+	if b == nil {
+		return
+	}
+	if unicast {
+		s.conn.WriteToUDP(b, src)
+	} else {
+		s.conn.WriteToUDP(b, mdnsGroup)
+	}
+}
+
+// 生成查询的响应，legacy 表示来自普通 DNS 客户端（源端口不是 5353），
+// 无需响应时返回 nil，unicast 为 true 时应直接发给查询方
+func (s *MDNSServer) respond(msg []byte, legacy bool) (b []byte, unicast bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || h.Response {
+		return nil, false
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, false
+	}
+
+	var answers, extras []dnsmessage.Resource
+	ttl := uint32(mdnsTTL)
+	if legacy {
+		ttl = mdnsLegacyTTL
+	}
+	for _, q := range questions {
+		if q.Class&mdnsClassFlag != 0 {
+			unicast = true
+		}
+		name := q.Name.String()
+		switch {
+		case strings.EqualFold(name, mdnsServices) && matchType(q.Type, dnsmessage.TypePTR):
+			answers = append(answers, s.ptr(mdnsServicesName(), s.service, ttl))
+		case strings.EqualFold(name, s.service.String()) && matchType(q.Type, dnsmessage.TypePTR):
+			answers = append(answers, s.ptr(s.service, s.instance, ttl))
+			extras = append(extras, s.srv(ttl), s.txt(ttl))
+			extras = append(extras, s.addrs(ttl)...)
+		case strings.EqualFold(name, s.instance.String()):
+			if matchType(q.Type, dnsmessage.TypeSRV) {
+				answers = append(answers, s.srv(ttl))
+			}
+			if matchType(q.Type, dnsmessage.TypeTXT) {
+				answers = append(answers, s.txt(ttl))
+			}
+			extras = append(extras, s.addrs(ttl)...)
+		case strings.EqualFold(name, s.host.String()) && matchType(q.Type, dnsmessage.TypeA):
+			answers = append(answers, s.addrs(ttl)...)
+		}
+	}
+	if len(answers) == 0 {
+		return nil, false
+	}
+
+	hdr := dnsmessage.Header{Response: true, Authoritative: true}
+	if legacy {
+		// 普通 DNS 客户端的查询需原样带回 ID 及问题
+		hdr.ID = h.ID
+	} else {
+		questions = nil
+	}
+	if b, err = buildMDNS(hdr, questions, answers, extras); err != nil {
+		return nil, false
+	}
+	return b, legacy || unicast
+}
+
+// 主动通告服务记录，ttl 为 0 时表示下线
+func (s *MDNSServer) announce(ttl uint32) {
+	answers := []dnsmessage.Resource{s.ptr(s.service, s.instance, ttl), s.srv(ttl), s.txt(ttl)}
+	answers = append(answers, s.addrs(ttl)...)
+	if b, err := buildMDNS(dnsmessage.Header{Response: true, Authoritative: true}, nil, answers, nil); err == nil {
+		s.conn.WriteToUDP(b, mdnsGroup)
+	}
+}
+
+func (s *MDNSServer) ptr(name, target dnsmessage.Name, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.PTRResource{PTR: target},
+	}
+}
+
+func (s *MDNSServer) srv(ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: s.instance, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET | mdnsClassFlag, TTL: ttl},
+		Body:   &dnsmessage.SRVResource{Target: s.host, Port: uint16(s.svc.Port)},
+	}
+}
+
+func (s *MDNSServer) txt(ttl uint32) dnsmessage.Resource {
+	text := s.svc.Text
+	if len(text) == 0 {
+		text = []string{""}
+	}
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: s.instance, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET | mdnsClassFlag, TTL: ttl},
+		Body:   &dnsmessage.TXTResource{TXT: text},
+	}
+}
+
+// 本机所有局域网 IPv4 地址
+func (s *MDNSServer) addrs(ttl uint32) []dnsmessage.Resource {
+	var list []dnsmessage.Resource
+	for _, ip := range localIPv4s() {
+		list = append(list, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: s.host, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET | mdnsClassFlag, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: [4]byte(ip)},
+		})
+	}
+	return list
+}
+
+func localIPv4s() []net.IP {
+	var ips []net.IP
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				if ip := ipNet.IP.To4(); ip != nil && !ip.IsLinkLocalUnicast() {
+					ips = append(ips, ip)
+				}
+			}
+		}
+	}
+	return ips
+}
+
+func buildMDNS(hdr dnsmessage.Header, questions []dnsmessage.Question, answers, extras []dnsmessage.Resource) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, hdr)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if err := addResources(&b, answers); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	if err := addResources(&b, extras); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+func addResources(b *dnsmessage.Builder, list []dnsmessage.Resource) error {
+	var err error
+	for _, r := range list {
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			err = b.PTRResource(r.Header, *body)
+		case *dnsmessage.SRVResource:
+			err = b.SRVResource(r.Header, *body)
+		case *dnsmessage.TXTResource:
+			err = b.TXTResource(r.Header, *body)
+		case *dnsmessage.AResource:
+			err = b.AResource(r.Header, *body)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchType(t, want dnsmessage.Type) bool {
+	return t == want || t == dnsmessage.TypeALL
+}
+
+func mdnsServicesName() dnsmessage.Name {
+	return dnsmessage.MustNewName(mdnsServices)
+}
+
+// 实例名称可包含空格及中文，但不能包含点且不超过 63 字节
+func mdnsLabel(s string) string {
+	s = strings.ReplaceAll(s, ".", "-")
+	for len(s) > 63 {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+// 主机名只保留字母、数字及连字符
+func mdnsHostLabel(s string) string {
+	s, _, _ = strings.Cut(s, ".")
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('-')
+		}
+		if sb.Len() >= 63 {
+			break
+		}
+	}
+	if sb.Len() == 0 {
+		return "gfss"
+	}
+	return sb.String()
+}
+
+// 浏览到的服务实例
+type MDNSEntry struct {
+	Instance string            `json:"instance"`
+	Host     string            `json:"host"`
+	Port     int               `json:"port"`
+	IPs      []string          `json:"ips"`
+	Text     map[string]string `json:"text"`
+}
+
+// 在局域网中查找指定类型的服务，直到 ctx 结束
+func BrowseMDNS(ctx context.Context, service string) ([]MDNSEntry, error) {
+	serviceName, err := dnsmessage.NewName(service + ".local.")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	q := dnsmessage.Question{Name: serviceName, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET | mdnsClassFlag}
+	query, err := buildMDNS(dnsmessage.Header{}, []dnsmessage.Question{q}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.WriteToUDP(query, mdnsGroup); err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	br := newMDNSBrowser(serviceName)
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		br.add(buf[:n])
+	}
+	return br.list(), nil
+}
+
+// 汇总浏览时收到的响应，同一实例的记录可能分散在多个响应中
+type mdnsBrowser struct {
+	suffix  string // .<服务类型>.local.，小写
+	order   []string
+	entries map[string]*MDNSEntry
+	hosts   map[string][]string
+}
+
+func newMDNSBrowser(service dnsmessage.Name) *mdnsBrowser {
+	return &mdnsBrowser{
+		suffix:  "." + strings.ToLower(service.String()),
+		entries: make(map[string]*MDNSEntry),
+		hosts:   make(map[string][]string),
+	}
+}
+
+func (br *mdnsBrowser) entry(name dnsmessage.Name) *MDNSEntry {
+	key := strings.ToLower(name.String())
+	e, ok := br.entries[key]
+	if !ok {
+		instance := name.String()
+		e = &MDNSEntry{Instance: instance[:len(instance)-len(br.suffix)], Text: make(map[string]string)}
+		br.entries[key] = e
+		br.order = append(br.order, key)
+	}
+	return e
+}
+
+func (br *mdnsBrowser) add(msg []byte) {
+	var p dnsmessage.Parser
+	if h, err := p.Start(msg); err != nil || !h.Response {
+		return
+	}
+	p.SkipAllQuestions()
+	var list []dnsmessage.Resource
+	answers, _ := p.AllAnswers()
+	list = append(list, answers...)
+	p.SkipAllAuthorities()
+	extras, _ := p.AllAdditionals()
+	list = append(list, extras...)
+
+	for _, r := range list {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			ptr := strings.ToLower(body.PTR.String())
+			if "."+name == br.suffix && strings.HasSuffix(ptr, br.suffix) && r.Header.TTL > 0 {
+				br.entry(body.PTR)
+			}
+		case *dnsmessage.SRVResource:
+			if strings.HasSuffix(name, br.suffix) {
+				e := br.entry(r.Header.Name)
+				e.Host = strings.TrimSuffix(body.Target.String(), ".")
+				e.Port = int(body.Port)
+			}
+		case *dnsmessage.TXTResource:
+			if strings.HasSuffix(name, br.suffix) {
+				e := br.entry(r.Header.Name)
+				for _, kv := range body.TXT {
+					k, v, _ := strings.Cut(kv, "=")
+					e.Text[k] = v
+				}
+			}
+		case *dnsmessage.AResource:
+			ip := net.IP(body.A[:]).String()
+			host := strings.TrimSuffix(name, ".")
+			if !slices.Contains(br.hosts[host], ip) {
+				br.hosts[host] = append(br.hosts[host], ip)
+			}
+		}
+	}
+}
+
+// 已知端口的实例，按首次发现的顺序
+func (br *mdnsBrowser) list() []MDNSEntry {
+	list := make([]MDNSEntry, 0, len(br.order))
+	for _, name := range br.order {
+		e := br.entries[name]
+		if e.Port == 0 {
+			continue
+		}
+		e.IPs = br.hosts[strings.ToLower(e.Host)]
+		list = append(list, *e)
+	}
+	return list
+}
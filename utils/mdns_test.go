@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestMDNSLabel(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"文件共享 on PC", "文件共享 on PC"},
+		{"a.b.c", "a-b-c"},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+		// 截断时不拆分多字节字符
+		{strings.Repeat("文", 30), strings.Repeat("文", 21)},
+		{"x" + strings.Repeat("文", 30), "x" + strings.Repeat("文", 20)},
+	}
+	for _, tt := range tests {
+		if got := mdnsLabel(tt.in); got != tt.want {
+			t.Errorf("mdnsLabel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMDNSHostLabel(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"my-pc", "my-pc"},
+		{"My PC.lan", "My-PC"},
+		{"pc_01", "pc-01"},
+		{"主机", "--"},
+		{"", "gfss"},
+		{".local", "gfss"},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+	}
+	for _, tt := range tests {
+		if got := mdnsHostLabel(tt.in); got != tt.want {
+			t.Errorf("mdnsHostLabel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func newTestMDNSServer(t *testing.T) *MDNSServer {
+	t.Helper()
+	s, err := newMDNSServer(MDNSService{
+		Instance: "文件共享 on PC.lan",
+		Service:  "_http._tcp",
+		Host:     "pc-gfss",
+		Port:     8080,
+		Text:     []string{"app=gfss", "path=/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func mdnsQuery(t *testing.T, id uint16, name string, typ dnsmessage.Type, class dnsmessage.Class) []byte {
+	t.Helper()
+	q := dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: class}
+	b, err := buildMDNS(dnsmessage.Header{ID: id}, []dnsmessage.Question{q}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func parseMDNS(t *testing.T, b []byte) dnsmessage.Message {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMDNSNames(t *testing.T) {
+	s := newTestMDNSServer(t)
+	if got := s.instance.String(); got != "文件共享 on PC-lan._http._tcp.local." {
+		t.Errorf("instance = %q", got)
+	}
+	if got := s.host.String(); got != "pc-gfss.local." {
+		t.Errorf("host = %q", got)
+	}
+
+	// 未指定主机名时不使用系统主机名本身
+	s, err := newMDNSServer(MDNSService{Instance: "x", Service: "_http._tcp", Port: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.host.String(); !strings.HasSuffix(got, "-mdns.local.") {
+		t.Errorf("default host = %q", got)
+	}
+}
+
+func TestMDNSRespond(t *testing.T) {
+	s := newTestMDNSServer(t)
+
+	b, unicast := s.respond(mdnsQuery(t, 0, "_http._tcp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET), false)
+	if b == nil || unicast {
+		t.Fatalf("PTR query: response %t, unicast %t", b != nil, unicast)
+	}
+	m := parseMDNS(t, b)
+	if !m.Header.Response || !m.Header.Authoritative || len(m.Questions) != 0 {
+		t.Errorf("header = %+v, questions = %d", m.Header, len(m.Questions))
+	}
+	if len(m.Answers) != 1 {
+		t.Fatalf("answers = %d", len(m.Answers))
+	}
+	ptr, ok := m.Answers[0].Body.(*dnsmessage.PTRResource)
+	if !ok || ptr.PTR != s.instance || m.Answers[0].Header.TTL != mdnsTTL {
+		t.Errorf("PTR = %v", m.Answers[0])
+	}
+	// 共享的 PTR 记录不带 cache-flush，SRV、TXT 及 A 记录带
+	if m.Answers[0].Header.Class&mdnsClassFlag != 0 {
+		t.Error("PTR has cache-flush bit")
+	}
+	var srv *dnsmessage.SRVResource
+	var txt *dnsmessage.TXTResource
+	for _, r := range m.Additionals {
+		if r.Header.Class&mdnsClassFlag == 0 {
+			t.Errorf("%v without cache-flush bit", r.Header.Type)
+		}
+		switch body := r.Body.(type) {
+		case *dnsmessage.SRVResource:
+			srv = body
+		case *dnsmessage.TXTResource:
+			txt = body
+		case *dnsmessage.AResource:
+			if r.Header.Name != s.host {
+				t.Errorf("A record for %v", r.Header.Name)
+			}
+		}
+	}
+	if srv == nil || srv.Target.String() != "pc-gfss.local." || srv.Port != 8080 {
+		t.Errorf("SRV = %+v", srv)
+	}
+	if txt == nil || !slices.Equal(txt.TXT, []string{"app=gfss", "path=/"}) {
+		t.Errorf("TXT = %+v", txt)
+	}
+
+	// 查询中设置了 QU 位时单播响应
+	if _, unicast = s.respond(mdnsQuery(t, 0, "_http._tcp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET|mdnsClassFlag), false); !unicast {
+		t.Error("QU query answered by multicast")
+	}
+
+	// 普通 DNS 客户端的查询带回 ID 及问题，TTL 不超过 10 秒
+	b, unicast = s.respond(mdnsQuery(t, 0x1234, "文件共享 on PC-lan._http._tcp.local.", dnsmessage.TypeSRV, dnsmessage.ClassINET), true)
+	if b == nil || !unicast {
+		t.Fatalf("legacy query: response %t, unicast %t", b != nil, unicast)
+	}
+	m = parseMDNS(t, b)
+	if m.Header.ID != 0x1234 || len(m.Questions) != 1 || len(m.Answers) != 1 || m.Answers[0].Header.TTL != mdnsLegacyTTL {
+		t.Errorf("legacy response = %+v", m)
+	}
+
+	b, _ = s.respond(mdnsQuery(t, 0, mdnsServices, dnsmessage.TypePTR, dnsmessage.ClassINET), false)
+	if b == nil {
+		t.Fatal("no response to service enumeration")
+	}
+	if m = parseMDNS(t, b); len(m.Answers) != 1 || m.Answers[0].Body.(*dnsmessage.PTRResource).PTR != s.service {
+		t.Errorf("service enumeration = %+v", m.Answers)
+	}
+
+	// 与本服务无关的查询不响应，系统主机名交给系统 mDNS 服务
+	for _, name := range []string{"_ipp._tcp.local.", "other._http._tcp.local.", "pc.local."} {
+		if b, _ = s.respond(mdnsQuery(t, 0, name, dnsmessage.TypeALL, dnsmessage.ClassINET), false); b != nil {
+			t.Errorf("answered query for %s", name)
+		}
+	}
+	if b, _ = s.respond(mdnsQuery(t, 0, "_http._tcp.local.", dnsmessage.TypeSRV, dnsmessage.ClassINET), false); b != nil {
+		t.Error("answered SRV query for service type")
+	}
+
+	// 不响应其他主机的响应
+	resp, _ := s.respond(mdnsQuery(t, 0, "_http._tcp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET), false)
+	if b, _ = s.respond(resp, false); b != nil {
+		t.Error("answered a response")
+	}
+}
+
+func TestMDNSBrowser(t *testing.T) {
+	s := newTestMDNSServer(t)
+	br := newMDNSBrowser(s.service)
+
+	resp, _ := s.respond(mdnsQuery(t, 0, "_http._tcp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET), false)
+	br.add(resp)
+
+	build := func(answers ...dnsmessage.Resource) []byte {
+		b, err := buildMDNS(dnsmessage.Header{Response: true, Authoritative: true}, nil, answers, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	hdr := func(name string, typ dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET, TTL: ttl}
+	}
+	// 地址在单独的响应中，名称大小写不同
+	br.add(build(dnsmessage.Resource{
+		Header: hdr("PC-GFSS.local.", dnsmessage.TypeA, 120),
+		Body:   &dnsmessage.AResource{A: [4]byte{192, 168, 1, 5}},
+	}))
+	br.add(build(dnsmessage.Resource{
+		Header: hdr("文件共享 on PC-lan._HTTP._tcp.local.", dnsmessage.TypeTXT, 120),
+		Body:   &dnsmessage.TXTResource{TXT: []string{"scheme=https"}},
+	}))
+	// 下线通知、其他服务类型及没有 SRV 的实例被忽略
+	br.add(build(
+		dnsmessage.Resource{
+			Header: hdr("_http._tcp.local.", dnsmessage.TypePTR, 0),
+			Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("gone._http._tcp.local.")},
+		},
+		dnsmessage.Resource{
+			Header: hdr("_ipp._tcp.local.", dnsmessage.TypePTR, 120),
+			Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("printer._ipp._tcp.local.")},
+		},
+		dnsmessage.Resource{
+			Header: hdr("_http._tcp.local.", dnsmessage.TypePTR, 120),
+			Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("noport._http._tcp.local.")},
+		},
+	))
+	// 查询报文被忽略
+	br.add(mdnsQuery(t, 0, "_http._tcp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET))
+
+	list := br.list()
+	if len(list) != 1 {
+		t.Fatalf("entries = %+v", list)
+	}
+	e := list[0]
+	if e.Instance != "文件共享 on PC-lan" || e.Host != "pc-gfss.local" || e.Port != 8080 {
+		t.Errorf("entry = %+v", e)
+	}
+	if e.Text["app"] != "gfss" || e.Text["path"] != "/" || e.Text["scheme"] != "https" {
+		t.Errorf("text = %v", e.Text)
+	}
+	if !slices.Contains(e.IPs, "192.168.1.5") {
+		t.Errorf("ips = %v", e.IPs)
+	}
+}
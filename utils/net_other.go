@@ -0,0 +1,20 @@
+//go:build !windows
+
+package utils
+
+import (
+	"fmt"
+	"net"
+)
+
+// 获取可用端口，逐个尝试监听直到成功
+func GetFreePort(p int64) int64 {
+	for {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", p))
+		if err == nil {
+			l.Close()
+			return p
+		}
+		p++
+	}
+}
@@ -0,0 +1,547 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "重新生成 testdata 中的二维码矩阵")
+
+// GF(256) 对数表，独立于被测实现
+var gfExp [256]byte
+var gfLog [256]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+}
+
+func gfMulRef(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(gfLog[a]+gfLog[b])%255]
+}
+
+// 格式信息（已异或 101010000010010），按纠错等级及掩码索引，见 ISO/IEC 18004 表 C.1
+var formatTable = [4][8]string{
+	L: {"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+		"110011000101111", "110001100011000", "110110001000001", "110100101110110"},
+	M: {"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+		"100010111111001", "100000011001110", "100111110010111", "100101010100000"},
+	Q: {"011010101011111", "011000001101000", "011111100110001", "011101000000110",
+		"010010010110100", "010000110000011", "010111011011010", "010101111101101"},
+	H: {"001011010001001", "001001110111110", "001110011100111", "001100111010000",
+		"000011101100010", "000001001010101", "000110100001100", "000100000111011"},
+}
+
+// 版本 7~40 的版本信息，见表 D.1
+var versionTable = []int{
+	0x07C94, 0x085BC, 0x09A99, 0x0A4D3, 0x0BBF6, 0x0C762, 0x0D847, 0x0E60D, 0x0F928, 0x10B78,
+	0x1145D, 0x12A17, 0x13532, 0x149A6, 0x15683, 0x168C9, 0x177EC, 0x18EC4, 0x191E1, 0x1AFAB,
+	0x1B08E, 0x1CC1A, 0x1D33F, 0x1ED75, 0x1F250, 0x209D5, 0x216F0, 0x228BA, 0x2379F, 0x24B0B,
+	0x2542E, 0x26A64, 0x27541, 0x28C69,
+}
+
+// 校正图形中心坐标，见表 E.1
+var alignTable = [41][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50}, 11: {6, 30, 54},
+	12: {6, 32, 58}, 13: {6, 34, 62}, 14: {6, 26, 46, 66}, 15: {6, 26, 48, 70}, 16: {6, 26, 50, 74},
+	17: {6, 30, 54, 78}, 18: {6, 30, 56, 82}, 19: {6, 30, 58, 86}, 20: {6, 34, 62, 90},
+	21: {6, 28, 50, 72, 94}, 22: {6, 26, 50, 74, 98}, 23: {6, 30, 54, 78, 102}, 24: {6, 28, 54, 80, 106},
+	25: {6, 32, 58, 84, 110}, 26: {6, 30, 58, 86, 114}, 27: {6, 34, 62, 90, 118},
+	28: {6, 26, 50, 74, 98, 122}, 29: {6, 30, 54, 78, 102, 126}, 30: {6, 26, 52, 78, 104, 130},
+	31: {6, 30, 56, 82, 108, 134}, 32: {6, 34, 60, 86, 112, 138}, 33: {6, 30, 58, 86, 114, 142},
+	34: {6, 34, 62, 90, 118, 146}, 35: {6, 30, 54, 78, 102, 126, 150}, 36: {6, 24, 50, 76, 102, 128, 154},
+	37: {6, 28, 54, 80, 106, 132, 158}, 38: {6, 32, 58, 84, 110, 136, 162}, 39: {6, 26, 54, 82, 110, 138, 166},
+	40: {6, 30, 58, 86, 114, 142, 170},
+}
+
+func TestFormatBits(t *testing.T) {
+	for level := L; level <= H; level++ {
+		for mask := 0; mask < 8; mask++ {
+			c := newCode(1)
+			c.drawFormat(level, mask)
+			f1, f2 := readFormat(c)
+			want := formatTable[level][mask]
+			if got := fmt.Sprintf("%015b", f1); got != want {
+				t.Errorf("level %d mask %d: format = %s, want %s", level, mask, got, want)
+			}
+			if f1 != f2 {
+				t.Errorf("level %d mask %d: format copies differ %015b %015b", level, mask, f1, f2)
+			}
+		}
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	for version := 7; version <= 40; version++ {
+		c := newCode(version)
+		c.drawFunctions(M)
+		v1, v2 := readVersion(c)
+		if want := versionTable[version-7]; v1 != want || v2 != want {
+			t.Errorf("version %d: bits = %05X %05X, want %05X", version, v1, v2, want)
+		}
+	}
+}
+
+func TestAlignPositions(t *testing.T) {
+	for version := 1; version <= 40; version++ {
+		got := alignPositions(version)
+		if fmt.Sprint(got) != fmt.Sprint(alignTable[version]) {
+			t.Errorf("version %d: align = %v, want %v", version, got, alignTable[version])
+		}
+	}
+}
+
+// 生成多项式的系数指数（不含最高次项），见附录 A
+func TestGeneratorPolynomials(t *testing.T) {
+	tests := map[int][]int{
+		7:  {87, 229, 146, 149, 238, 102, 21},
+		10: {251, 67, 46, 61, 118, 70, 64, 94, 32, 45},
+		13: {74, 152, 176, 100, 86, 100, 106, 104, 130, 218, 206, 140, 78},
+		15: {8, 183, 61, 91, 202, 37, 51, 58, 58, 237, 140, 124, 5, 99, 105},
+		16: {120, 104, 107, 109, 102, 161, 76, 3, 91, 191, 147, 169, 182, 194, 225, 120},
+		17: {43, 139, 206, 78, 43, 239, 123, 206, 214, 147, 24, 99, 150, 39, 243, 163, 136},
+		18: {215, 234, 158, 94, 184, 97, 118, 170, 79, 187, 152, 148, 252, 179, 5, 98, 96, 153},
+		20: {17, 60, 79, 50, 61, 163, 26, 187, 202, 180, 221, 225, 83, 239, 156, 164, 212, 212, 188, 190},
+		22: {210, 171, 247, 242, 93, 230, 14, 109, 221, 53, 200, 74, 8, 172, 98, 80, 219, 134, 160, 105,
+			165, 231},
+		24: {229, 121, 135, 48, 211, 117, 251, 126, 159, 180, 169, 152, 192, 226, 228, 218, 111, 0, 117, 232,
+			87, 96, 227, 21},
+		26: {173, 125, 158, 2, 103, 182, 118, 17, 145, 201, 111, 28, 165, 53, 161, 21, 245, 142, 13, 102,
+			48, 227, 153, 145, 218, 70},
+		28: {168, 223, 200, 104, 224, 234, 108, 180, 110, 190, 195, 147, 205, 27, 232, 201, 21, 43, 245, 87,
+			42, 195, 212, 119, 242, 37, 9, 123},
+		30: {41, 173, 145, 152, 216, 31, 179, 182, 50, 48, 110, 86, 239, 96, 222, 125, 42, 173, 226, 193,
+			224, 130, 156, 37, 251, 216, 238, 40, 192, 180},
+	}
+	for degree, exps := range tests {
+		got := rsDivisor(degree)
+		for i, e := range exps {
+			if got[i] != gfExp[e] {
+				t.Errorf("degree %d: coefficient %d = %d, want α^%d = %d", degree, i, got[i], e, gfExp[e])
+				break
+			}
+		}
+	}
+}
+
+func TestRSRemainder(t *testing.T) {
+	tests := []struct {
+		name      string
+		data, ecc []byte
+	}{
+		// 附录 I 示例 01234567，1-M
+		{"01234567",
+			[]byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11},
+			[]byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}},
+		// HELLO WORLD，1-M
+		{"HELLO WORLD",
+			[]byte{0x20, 0x5B, 0x0B, 0x78, 0xD1, 0x72, 0xDC, 0x4D, 0x43, 0x40, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11},
+			[]byte{0xC4, 0x23, 0x27, 0x77, 0xEB, 0xD7, 0xE7, 0xE2, 0x5D, 0x17}},
+	}
+	for _, tt := range tests {
+		if got := rsRemainder(tt.data, rsDivisor(len(tt.ecc))); !bytes.Equal(got, tt.ecc) {
+			t.Errorf("%s: ecc = % X, want % X", tt.name, got, tt.ecc)
+		}
+	}
+}
+
+// 字节模式容量，见表 7
+func TestCapacity(t *testing.T) {
+	tests := []struct {
+		version int
+		level   Level
+		bytes   int
+	}{
+		{1, L, 17}, {1, M, 14}, {1, Q, 11}, {1, H, 7},
+		{2, L, 32}, {2, M, 26}, {2, Q, 20}, {2, H, 14},
+		{7, L, 154}, {7, M, 122}, {7, Q, 86}, {7, H, 64},
+		{10, L, 271}, {10, M, 213}, {10, Q, 151}, {10, H, 119},
+		{40, L, 2953}, {40, M, 2331}, {40, Q, 1663}, {40, H, 1273},
+	}
+	for _, tt := range tests {
+		c, err := Encode(bytes.Repeat([]byte{'a'}, tt.bytes), tt.level)
+		if err != nil || c.Version != tt.version {
+			t.Errorf("%d-%d: %d bytes => %v, want version %d", tt.version, tt.level, tt.bytes, err, tt.version)
+			continue
+		}
+		c, err = Encode(bytes.Repeat([]byte{'a'}, tt.bytes+1), tt.level)
+		if tt.version == 40 {
+			if !errors.Is(err, ErrTooLong) {
+				t.Errorf("%d-%d: %d bytes => %v, want ErrTooLong", tt.version, tt.level, tt.bytes+1, err)
+			}
+		} else if err != nil || c.Version != tt.version+1 {
+			t.Errorf("%d-%d: %d bytes => %v, want next version", tt.version, tt.level, tt.bytes+1, err)
+		}
+	}
+	if _, err := Encode(nil, H+1); err == nil {
+		t.Error("invalid level accepted")
+	}
+}
+
+// 总码字数及剩余位，见表 1
+func TestRawModules(t *testing.T) {
+	tests := []struct{ version, codewords, remainder int }{
+		{1, 26, 0}, {2, 44, 7}, {6, 172, 7}, {7, 196, 0}, {10, 346, 0},
+		{14, 581, 3}, {21, 1156, 4}, {28, 1921, 3}, {35, 2876, 0}, {40, 3706, 0},
+	}
+	for _, tt := range tests {
+		n := rawModules(tt.version)
+		if n/8 != tt.codewords || n%8 != tt.remainder {
+			t.Errorf("version %d: %d codewords + %d bits, want %d + %d",
+				tt.version, n/8, n%8, tt.codewords, tt.remainder)
+		}
+	}
+}
+
+// 所有版本及纠错等级按容量编码后能正确解码
+func TestRoundTrip(t *testing.T) {
+	for level := L; level <= H; level++ {
+		for version := 1; version <= 40; version++ {
+			// 该版本的最大容量，TestCapacity 已核对部分版本
+			size := (dataCodewords(version, level)*8 - 4 - countBits(version)) / 8
+			data := make([]byte, size)
+			for i := range data {
+				data[i] = byte(i*7 + version)
+			}
+			c, err := Encode(data, level)
+			if err != nil {
+				t.Fatalf("%d-%d: %v", version, level, err)
+			}
+			if c.Version != version {
+				t.Fatalf("%d-%d: %d bytes encoded as version %d", version, level, size, c.Version)
+			}
+			got, gotLevel, _ := decode(t, c)
+			if gotLevel != level || !bytes.Equal(got, data) {
+				t.Fatalf("%d-%d: decoded level %d, data mismatch", version, level, gotLevel)
+			}
+		}
+	}
+}
+
+func TestMasks(t *testing.T) {
+	data := []byte("http://192.168.1.10:8080")
+	for mask := 0; mask < 8; mask++ {
+		c, err := encode(data, Q, mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, gotMask := decode(t, c)
+		if gotMask != mask || !bytes.Equal(got, data) {
+			t.Errorf("mask %d: decoded mask %d, data %q", mask, gotMask, got)
+		}
+	}
+}
+
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		level Level
+		mask  int
+	}{
+		{"v1-M-auto", "gfss", M, -1},
+		{"v2-L-mask2", "http://192.168.1.10:8080", L, 2},
+		{"v3-Q-mask6", "http://192.168.1.10:8080", Q, 6},
+		{"v4-H-mask1", "https://example.com/s/abc", H, 1},
+		{"v6-M-mask4", strings.Repeat("0123456789", 10), M, 4},
+		{"v7-H-mask5", strings.Repeat("abcdef", 10), H, 5},
+		{"v10-L-mask0", strings.Repeat("gfss ", 50), L, 0},
+		{"v12-Q-mask3", strings.Repeat("x", 200), Q, 3},
+		{"v13-L-mask7", strings.Repeat("QR", 200), L, 7},
+	}
+	for _, tt := range tests {
+		c, err := encode([]byte(tt.data), tt.level, tt.mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "v" + fmt.Sprint(c.Version) + "-"; !strings.HasPrefix(tt.name, want) {
+			t.Errorf("%s: encoded as version %d", tt.name, c.Version)
+		}
+		got, _, mask := decode(t, c)
+		if string(got) != tt.data || (tt.mask >= 0 && mask != tt.mask) {
+			t.Errorf("%s: decoded %q with mask %d", tt.name, got, mask)
+		}
+
+		file := filepath.Join("testdata", tt.name+".txt")
+		matrix := dump(c)
+		if *update {
+			if err := os.WriteFile(file, []byte(matrix), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matrix != string(want) {
+			t.Errorf("%s: matrix differs from %s", tt.name, file)
+		}
+	}
+}
+
+func dump(c *Code) string {
+	var sb strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Black(x, y) {
+				sb.WriteByte('#')
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func bit(c *Code, x, y int) int {
+	if c.Black(x, y) {
+		return 1
+	}
+	return 0
+}
+
+// 读取左上角及右上、左下两处格式信息，最高位在前
+func readFormat(c *Code) (f1, f2 int) {
+	for i := 0; i <= 5; i++ {
+		f1 |= bit(c, 8, i) << i
+	}
+	f1 |= bit(c, 8, 7)<<6 | bit(c, 8, 8)<<7 | bit(c, 7, 8)<<8
+	for i := 9; i < 15; i++ {
+		f1 |= bit(c, 14-i, 8) << i
+	}
+	for i := 0; i < 8; i++ {
+		f2 |= bit(c, c.Size-1-i, 8) << i
+	}
+	for i := 8; i < 15; i++ {
+		f2 |= bit(c, 8, c.Size-15+i) << i
+	}
+	return
+}
+
+// 读取右上及左下两处版本信息
+func readVersion(c *Code) (v1, v2 int) {
+	for i := 0; i < 18; i++ {
+		v1 |= bit(c, c.Size-11+i%3, i/3) << i
+		v2 |= bit(c, i/3, c.Size-11+i%3) << i
+	}
+	return
+}
+
+func maskBit(mask, x, y int) bool {
+	i, j := y, x
+	switch mask {
+	case 0:
+		return (i+j)%2 == 0
+	case 1:
+		return i%2 == 0
+	case 2:
+		return j%3 == 0
+	case 3:
+		return (i+j)%3 == 0
+	case 4:
+		return (i/2+j/3)%2 == 0
+	case 5:
+		return (i*j)%2+(i*j)%3 == 0
+	case 6:
+		return ((i*j)%2+(i*j)%3)%2 == 0
+	default:
+		return ((i+j)%2+(i*j)%3)%2 == 0
+	}
+}
+
+// 按标准独立解码：校验格式及版本信息、各块纠错码，解析字节模式数据
+func decode(t *testing.T, c *Code) (data []byte, level Level, mask int) {
+	t.Helper()
+	size, version := c.Size, (c.Size-17)/4
+	if size != version*4+17 || version < 1 || version > 40 {
+		t.Fatalf("invalid size %d", size)
+	}
+
+	f1, f2 := readFormat(c)
+	if f1 != f2 {
+		t.Fatalf("format copies differ %015b %015b", f1, f2)
+	}
+	level, mask = -1, -1
+	for l := L; l <= H; l++ {
+		for m := 0; m < 8; m++ {
+			if formatTable[l][m] == fmt.Sprintf("%015b", f1) {
+				level, mask = l, m
+			}
+		}
+	}
+	if level < 0 {
+		t.Fatalf("invalid format %015b", f1)
+	}
+	if bit(c, 8, size-8) != 1 {
+		t.Fatal("dark module missing")
+	}
+	if version >= 7 {
+		if v1, v2 := readVersion(c); v1 != versionTable[version-7] || v2 != v1 {
+			t.Fatalf("version %d: bits %05X %05X", version, v1, v2)
+		}
+	}
+
+	// 功能图形区域
+	fn := make([][]bool, size)
+	for i := range fn {
+		fn[i] = make([]bool, size)
+	}
+	mark := func(x0, y0, w, h int) {
+		for y := y0; y < y0+h; y++ {
+			for x := x0; x < x0+w; x++ {
+				fn[y][x] = true
+			}
+		}
+	}
+	mark(0, 0, 9, 9)
+	mark(size-8, 0, 8, 9)
+	mark(0, size-8, 9, 8)
+	mark(6, 0, 1, size)
+	mark(0, 6, size, 1)
+	pos := alignTable[version]
+	for _, x := range pos {
+		for _, y := range pos {
+			if (x == 6 && y == 6) || (x == 6 && y == size-7) || (x == size-7 && y == 6) {
+				continue
+			}
+			mark(x-2, y-2, 5, 5)
+		}
+	}
+	if version >= 7 {
+		mark(size-11, 0, 3, 6)
+		mark(0, size-11, 6, 3)
+	}
+
+	// 从右下角两列一组蛇形读取并去除掩码
+	var bits []bool
+	up := true
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for k := 0; k < size; k++ {
+			y := k
+			if up {
+				y = size - 1 - k
+			}
+			for _, x := range []int{right, right - 1} {
+				if !fn[y][x] {
+					bits = append(bits, c.Black(x, y) != maskBit(mask, x, y))
+				}
+			}
+		}
+		up = !up
+	}
+	if len(bits) != rawModules(version) {
+		t.Fatalf("version %d: %d data modules, want %d", version, len(bits), rawModules(version))
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for j := 0; j < 8; j++ {
+			if bits[i*8+j] {
+				codewords[i] |= 0x80 >> j
+			}
+		}
+	}
+	for _, b := range bits[len(codewords)*8:] {
+		if b {
+			t.Errorf("version %d: remainder bit set", version)
+			break
+		}
+	}
+
+	// 解交错并校验每块的纠错码：码字多项式在 α^0..α^(n-1) 处的值均为 0
+	numBlocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	total := len(codewords)
+	shortLen := total / numBlocks
+	numShort := numBlocks - total%numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortLen-eccLen; i++ {
+		for j := range blocks {
+			n := shortLen - eccLen
+			if j >= numShort {
+				n++
+			}
+			if i < n {
+				blocks[j] = append(blocks[j], codewords[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], codewords[k])
+			k++
+		}
+	}
+	var payload []byte
+	for j, block := range blocks {
+		for r := 0; r < eccLen; r++ {
+			var s byte
+			for _, b := range block {
+				s = gfMulRef(s, gfExp[r]) ^ b
+			}
+			if s != 0 {
+				t.Fatalf("version %d level %d block %d: syndrome %d = %d", version, level, j, r, s)
+			}
+		}
+		payload = append(payload, block[:len(block)-eccLen]...)
+	}
+
+	// 模式指示、长度、数据、终止符及填充
+	pos2 := 0
+	read := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(payload[pos2/8]>>(7-pos2%8)&1)
+			pos2++
+		}
+		return v
+	}
+	if m := read(4); m != 4 {
+		t.Fatalf("mode = %d, want byte mode", m)
+	}
+	countBits := 8
+	if version > 9 {
+		countBits = 16
+	}
+	data = make([]byte, read(countBits))
+	for i := range data {
+		data[i] = byte(read(8))
+	}
+	if rest := len(payload)*8 - pos2; rest > 0 {
+		if read(min(4, rest)) != 0 {
+			t.Fatal("terminator not zero")
+		}
+		if read((8-pos2%8)%8) != 0 {
+			t.Fatal("padding bits not zero")
+		}
+		for i, pad := pos2/8, byte(0xEC); i < len(payload); i, pad = i+1, pad^0xEC^0x11 {
+			if payload[i] != pad {
+				t.Fatalf("pad codeword %d = %02X, want %02X", i, payload[i], pad)
+			}
+		}
+	}
+	return data, level, mask
+}
@@ -15,17 +15,30 @@ type SSEClient struct {
 	ch       chan string
 }
 
+// 心跳间隔，防止代理断开长时间无数据的连接
+const sseHeartbeat = 15 * time.Second
+
 type SSEManager struct {
 	clients map[http.ResponseWriter]SSEClient
 	Mutex   sync.Mutex
+	done    chan struct{}
+	once    sync.Once
 }
 
 func NewSSEManager() *SSEManager {
 	return &SSEManager{
 		clients: make(map[http.ResponseWriter]SSEClient),
+		done:    make(chan struct{}),
 	}
 }
 
+// 断开所有连接，服务关闭时调用，避免长连接拖慢退出
+func (t *SSEManager) Close() {
+	t.once.Do(func() {
+		close(t.done)
+	})
+}
+
 type SSEData struct {
 	Event string `json:"event"`
 	Data  any    `json:"data,omitempty"`
@@ -39,6 +52,14 @@ func (t *SSEManager) BroadcastLocal(event string, data any) {
 	t.broadcast(true, b)
 }
 
+// 仅 GUI 模式下推送给本机客户端，如托盘的查看连接
+func (t *SSEManager) BroadcastGui(event string, data any) {
+	if !IsGuiMode {
+		return
+	}
+	t.BroadcastLocal(event, data)
+}
+
 func (t *SSEManager) Broadcast(event string, data any) {
 	b, _ := json.Marshal(SSEData{
 		Event: event,
@@ -88,11 +109,13 @@ func (t *SSEManager) IPs() (list []SSEClientRsp) {
 	return
 }
 
+func (t *SSEManager) Count() int {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	return len(t.clients)
+}
+
 func (t *SSEManager) SSE(c *Ctx) {
-	if !IsGuiMode {
-		http.Error(c.W, "不支持SSE", http.StatusInternalServerError)
-		return
-	}
 	flusher, ok := c.W.(http.Flusher)
 	if !ok {
 		http.Error(c.W, "不支持流式输出", http.StatusInternalServerError)
@@ -114,6 +137,10 @@ func (t *SSEManager) SSE(c *Ctx) {
 	}
 	t.Mutex.Unlock()
 
+	// 立即发送响应头，客户端无需等待首条消息即可确认连接
+	c.W.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
 	defer func() {
 		t.Mutex.Lock()
 		delete(t.clients, c.W)
@@ -121,10 +148,19 @@ func (t *SSEManager) SSE(c *Ctx) {
 		close(ch)
 	}()
 
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-c.R.Context().Done():
 			return
+		case <-t.done:
+			return
+		case <-ticker.C:
+			// 注释行，客户端会忽略
+			fmt.Fprint(c.W, ": ping\n\n")
+			flusher.Flush()
 		case msg := <-ch:
 			// SSE 标准格式 data:xxx\n\n
 			fmt.Fprintf(c.W, "data: %s\n\n", msg)
@@ -0,0 +1,59 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func openCmd() string {
+	if runtime.GOOS == "darwin" {
+		return "open"
+	}
+	return "xdg-open"
+}
+
+func ExplorerOpen(path string) {
+	exec.Command(openCmd(), path).Start()
+}
+
+func CmdStart(url string) {
+	go func() {
+		cmd := exec.Command(openCmd(), url)
+		if cmd.Start() == nil {
+			cmd.Wait()
+		}
+	}()
+}
+
+// 单实例锁，通过对临时目录下的锁文件加 flock 实现，进程退出后自动释放，返回释放锁的函数
+func CheckSingleInstance(s string) (bool, func()) {
+	name := s[strings.LastIndex(s, `\`)+1:]
+	lockFile := filepath.Join(os.TempDir(), name+".lock")
+	f, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return false, nil
+	}
+	if err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		return false, nil
+	}
+	return true, func() { f.Close() }
+}
+
+// SelectFolder 通过 zenity 弹出选择文件夹对话框
+// title: 窗口提示文字
+// 返回选中路径，取消或未安装 zenity 则返回空字符串
+func SelectFolder(title string) string {
+	out, err := exec.Command("zenity", "--file-selection", "--directory", "--title="+title).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
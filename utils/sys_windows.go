@@ -8,9 +8,6 @@ import (
 	"golang.org/x/sys/windows"
 )
 
-var GuiMode string
-var IsGuiMode = GuiMode == "1"
-
 var (
 	kernel32                  = syscall.NewLazyDLL("kernel32.dll")
 	procGetStdHandle          = kernel32.NewProc("GetStdHandle")
@@ -71,20 +68,20 @@ func CmdStart(url string) {
 	}()
 }
 
-// 单实例锁，通过内核互斥体 Mutex 实现只允许运行一个程序实例
-func CheckSingleInstance(s string) (bool, windows.Handle) {
+// 单实例锁，通过内核互斥体 Mutex 实现只允许运行一个程序实例，返回释放锁的函数
+func CheckSingleInstance(s string) (bool, func()) {
 	mutex, err := windows.CreateMutex(nil, false,
 		windows.StringToUTF16Ptr(s))
 	if err != nil {
-		return false, 0
+		return false, nil
 	}
 
 	if windows.GetLastError() == windows.ERROR_ALREADY_EXISTS {
 		_ = windows.CloseHandle(mutex)
-		return false, 0
+		return false, nil
 	}
 
-	return true, mutex
+	return true, func() { windows.CloseHandle(mutex) }
 }
 
 // SelectFolder 弹出Windows选择文件夹对话框